@@ -0,0 +1,365 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdminState holds operator overrides: datasets pinned for specific
+// days and datasets never to be selected.  It's saved to disk after
+// every change, and to shared storage too once it's shared.
+type AdminState struct {
+	lock  sync.RWMutex
+	path  string
+	store Storage
+	name  string
+
+	// Pins are keyed by date (YYYY-MM-DD), the blacklist by Dataset.Key
+	Pins      map[string]Dataset
+	Blacklist map[string]Dataset
+}
+
+// LoadAdminState reads saved overrides, starting empty if there are
+// none.
+func LoadAdminState(path string) *AdminState {
+	a := &AdminState{
+		path:      path,
+		Pins:      map[string]Dataset{},
+		Blacklist: map[string]Dataset{},
+	}
+
+	fin, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error opening admin state file:", err)
+		}
+		return a
+	}
+	defer fin.Close()
+
+	if err := a.decode(fin); err != nil {
+		log.Println("Error reading admin state file:", err)
+	}
+	return a
+}
+
+// Share keeps the overrides in shared storage under name as well, so
+// a change made through any replica reaches the one that selects.
+// Overrides already in storage replace the ones read from the file.
+func (a *AdminState) Share(store Storage, name string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.store, a.name = store, name
+	a.reload()
+}
+
+// Reload picks up changes other replicas have made to the shared
+// overrides.  It does nothing if they aren't shared.
+func (a *AdminState) Reload() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.reload()
+}
+
+// reload must be called with the lock held.
+func (a *AdminState) reload() {
+	if a.store == nil {
+		return
+	}
+	data, err := a.store.Get(a.name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error reading shared admin state:", err)
+		}
+		return
+	}
+	if err := a.decode(bytes.NewReader(data)); err != nil {
+		log.Println("Error reading shared admin state:", err)
+	}
+}
+
+// decode must be called with the lock held, or before a is shared.
+func (a *AdminState) decode(fin io.Reader) error {
+	loaded := AdminState{}
+	if err := gob.NewDecoder(fin).Decode(&loaded); err != nil {
+		return err
+	}
+	a.Pins, a.Blacklist = loaded.Pins, loaded.Blacklist
+	if a.Pins == nil {
+		a.Pins = map[string]Dataset{}
+	}
+	if a.Blacklist == nil {
+		a.Blacklist = map[string]Dataset{}
+	}
+	return nil
+}
+
+// save must be called with the lock held.
+func (a *AdminState) save() error {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(a); err != nil {
+		return err
+	}
+	if a.store != nil {
+		if err := a.store.Put(a.name, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(a.path, buf.Bytes(), 0666)
+}
+
+func (a *AdminState) PinFor(t time.Time) (Dataset, bool) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	d, ok := a.Pins[t.Format(timeFormat)]
+	return d, ok
+}
+
+func (a *AdminState) Pin(date string, d Dataset) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.reload()
+	a.Pins[date] = d
+	return a.save()
+}
+
+func (a *AdminState) Unpin(date string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.reload()
+	delete(a.Pins, date)
+	return a.save()
+}
+
+func (a *AdminState) SetBlacklisted(d Dataset, blacklisted bool) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.reload()
+	if blacklisted {
+		a.Blacklist[d.Key()] = d
+	} else {
+		delete(a.Blacklist, d.Key())
+	}
+	return a.save()
+}
+
+func (a *AdminState) FilterBlacklisted(
+	candidates []DailySelection,
+) []DailySelection {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	out := make([]DailySelection, 0, len(candidates))
+	for _, v := range candidates {
+		if _, ok := a.Blacklist[v.Dataset.Key()]; !ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func selectionConflict(w http.ResponseWriter) {
+	http.Error(w, "Selection already in progress", http.StatusConflict)
+}
+
+// AdminHandler serves the operator endpoints under /admin/.  Every
+// request must carry the configured token as a bearer token, and
+// every attempt, successful or not, goes to the audit log.
+func AdminHandler(
+	config Config,
+	selection *SelectionStore,
+	admin *AdminState,
+	candidates *CandidateList,
+	refresh func(),
+	audit *log.Logger,
+) http.Handler {
+	token := []byte("Bearer " + config.AdminToken)
+
+	authorized := func(r *http.Request) bool {
+		given := []byte(r.Header.Get("Authorization"))
+		return subtle.ConstantTimeCompare(given, token) == 1
+	}
+
+	lookupDataset := func(w http.ResponseWriter, key string) (Dataset, bool) {
+		d, ok := FindDataset(key)
+		if !ok {
+			http.Error(w, "Unknown dataset "+key, http.StatusBadRequest)
+		}
+		return d, ok
+	}
+
+	lookupDate := func(w http.ResponseWriter, date string) (string, bool) {
+		if date == "" {
+			now := config.Clock.Now()
+			return now.In(config.Schedule.Location).Format(timeFormat), true
+		}
+		if _, err := time.Parse(timeFormat, date); err != nil {
+			http.Error(w, "Invalid date "+date, http.StatusBadRequest)
+			return "", false
+		}
+		return date, true
+	}
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			action := strings.TrimPrefix(r.URL.Path, "/admin/")
+			r.ParseForm()
+
+			if !authorized(r) {
+				audit.Printf(
+					"DENIED %s %s %s",
+					ClientIP(r),
+					action,
+					r.Form.Encode(),
+				)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if action != "candidates" && action != "overrides" &&
+				r.Method != http.MethodPost {
+				http.Error(
+					w,
+					"Method not allowed",
+					http.StatusMethodNotAllowed,
+				)
+				return
+			}
+
+			audit.Printf("%s %s %s", ClientIP(r), action, r.Form.Encode())
+
+			switch action {
+			case "refresh":
+				if SelectionInProgress() {
+					selectionConflict(w)
+					return
+				}
+				go refresh()
+				w.WriteHeader(http.StatusAccepted)
+
+			case "pin":
+				d, ok := lookupDataset(w, r.FormValue("dataset"))
+				if !ok {
+					return
+				}
+				date, ok := lookupDate(w, r.FormValue("date"))
+				if !ok {
+					return
+				}
+
+				// A pin for today takes effect immediately, so it
+				// can't overlap a selection that may have already
+				// read the pins or be about to store its own pick
+				now := config.Clock.Now()
+				today := now.In(config.Schedule.Location).Format(timeFormat)
+				if date == today {
+					if !beginSelection() {
+						selectionConflict(w)
+						return
+					}
+					defer endSelection()
+				}
+				if err := admin.Pin(date, d); err != nil {
+					panic(err)
+				}
+
+				if date == today {
+					result, err := GetRequest(config.APIKey, now, d)
+					if err != nil {
+						audit.Printf("Pin of %s failed: %s", d.Key(), err)
+						http.Error(w, err.Error(), http.StatusBadGateway)
+						return
+					}
+					StoreSelection(
+						config,
+						DailySelection{d, result, now},
+						selection,
+					)
+				}
+				w.WriteHeader(http.StatusNoContent)
+
+			case "unpin":
+				date, ok := lookupDate(w, r.FormValue("date"))
+				if !ok {
+					return
+				}
+				today := config.Clock.Now().In(config.Schedule.Location)
+				if date == today.Format(timeFormat) {
+					if !beginSelection() {
+						selectionConflict(w)
+						return
+					}
+					defer endSelection()
+				}
+				if err := admin.Unpin(date); err != nil {
+					panic(err)
+				}
+				w.WriteHeader(http.StatusNoContent)
+
+			case "blacklist", "unblacklist":
+				d, ok := lookupDataset(w, r.FormValue("dataset"))
+				if !ok {
+					return
+				}
+				err := admin.SetBlacklisted(d, action == "blacklist")
+				if err != nil {
+					panic(err)
+				}
+				w.WriteHeader(http.StatusNoContent)
+
+			case "candidates":
+				t, list := candidates.Get()
+				writeJSON(w, candidateResponse(t, RankCandidates(list)))
+
+			case "overrides":
+				admin.Reload()
+				admin.lock.RLock()
+				defer admin.lock.RUnlock()
+				writeJSON(w, map[string]interface{}{
+					"pins":      admin.Pins,
+					"blacklist": admin.Blacklist,
+				})
+
+			default:
+				http.NotFound(w, r)
+			}
+		},
+	)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(v); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(
+	handler http.Handler,
+	action string,
+	form url.Values,
+) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(
+		"POST",
+		"/admin/"+action,
+		strings.NewReader(form.Encode()),
+	)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminPinDuringSelection(t *testing.T) {
+	config := testConfig(t)
+	config.AdminToken = "secret"
+	config.Clock = newFakeClock(time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC))
+	dir := config.Cache.(LocalStorage).Dir
+	admin := LoadAdminState(filepath.Join(dir, "admin"))
+	handler := AdminHandler(
+		config,
+		NewSelectionStore(),
+		admin,
+		LoadCandidateList(filepath.Join(dir, "candidates")),
+		func() {},
+		log.New(ioutil.Discard, "", 0),
+	)
+	dataset := Datasets[0].Key()
+
+	if !beginSelection() {
+		t.Fatal("Selection already running")
+	}
+	defer endSelection()
+
+	for _, action := range []string{"pin", "unpin"} {
+		w := adminRequest(handler, action, url.Values{
+			"dataset": {dataset},
+			"date":    {"2017-06-01"},
+		})
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected %s for today to conflict, got %d", action, w.Code)
+		}
+	}
+	if _, ok := admin.PinFor(config.Clock.Now()); ok {
+		t.Error("Pin for today was recorded during a selection")
+	}
+
+	// Other days don't touch the running selection
+	w := adminRequest(handler, "pin", url.Values{
+		"dataset": {dataset},
+		"date":    {"2017-06-02"},
+	})
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected a pin for tomorrow to work, got %d", w.Code)
+	}
+	if _, ok := admin.PinFor(config.Clock.Now().AddDate(0, 0, 1)); !ok {
+		t.Error("Pin for tomorrow wasn't recorded")
+	}
+}
@@ -1,254 +0,0 @@
-/*
- * Copyright 2017, Robert Bieber
- *
- * This file is part of dailyhindsight.
- *
- * dailyhindsight is free software: you can redistribute it and/or modify it
- * under the terms of the GNU General Public License as published by
- * the Free Software Foundation, either version 3 of the License, or
- * (at your option) any later version.
- *
- * dailyhindsight is distributed in the hope that it will be useful,
- * WITHOUT ANY WARRANTY; without even the implied warranty of
- * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
- * GNU General Public License for more details.
- *
- * You should have received a copy of the GNU General Public License
- * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
- */
-
-// Code generated by go-bindata.
-// sources:
-// favicon.ico
-// DO NOT EDIT!
-
-package main
-
-import (
-	"bytes"
-	"compress/gzip"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"os"
-	"path/filepath"
-	"strings"
-	"time"
-)
-
-func bindataRead(data []byte, name string) ([]byte, error) {
-	gz, err := gzip.NewReader(bytes.NewBuffer(data))
-	if err != nil {
-		return nil, fmt.Errorf("Read %q: %v", name, err)
-	}
-
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, gz)
-	clErr := gz.Close()
-
-	if err != nil {
-		return nil, fmt.Errorf("Read %q: %v", name, err)
-	}
-	if clErr != nil {
-		return nil, err
-	}
-
-	return buf.Bytes(), nil
-}
-
-type asset struct {
-	bytes []byte
-	info  os.FileInfo
-}
-
-type bindataFileInfo struct {
-	name    string
-	size    int64
-	mode    os.FileMode
-	modTime time.Time
-}
-
-func (fi bindataFileInfo) Name() string {
-	return fi.name
-}
-func (fi bindataFileInfo) Size() int64 {
-	return fi.size
-}
-func (fi bindataFileInfo) Mode() os.FileMode {
-	return fi.mode
-}
-func (fi bindataFileInfo) ModTime() time.Time {
-	return fi.modTime
-}
-func (fi bindataFileInfo) IsDir() bool {
-	return false
-}
-func (fi bindataFileInfo) Sys() interface{} {
-	return nil
-}
-
-var _faviconIco = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x62\x60\x60\x04\x42\x25\x09\x10\xad\xc0\xb0\x82\x97\x81\x41\x8c\x81\x81\x41\x03\x88\x95\x80\xd8\x80\x01\x22\x3e\x0a\x46\xc1\x30\x01\xff\xd1\xf0\x3f\x20\xfe\x09\xc4\x6f\x80\xf8\x32\x10\xaf\x04\xe2\x18\x20\x66\xc3\x65\x40\x5d\x5d\xdd\x7f\x64\x4c\xa6\x1a\x74\x77\xe0\xc2\x8f\x81\xd8\x96\x8e\xee\x00\x01\x16\x20\x56\x04\xe2\x52\x20\xfe\x88\x24\xf7\x1b\x88\xdd\xe8\xe8\x0e\x64\x60\xc2\x00\x89\x27\x98\xfc\x5b\x20\x16\x18\x00\x77\x80\xc0\x04\x34\x35\x95\x03\xe4\x0e\x6b\x34\x35\x87\x07\xc8\x1d\x82\x68\x6a\x5e\x0f\x90\x3b\x58\xd0\xd4\xfc\xc2\x67\x07\x31\x98\x4c\x77\x08\xa0\xa9\x79\x33\x40\xee\xb0\x42\x53\x73\x04\x9f\x3b\xb0\x19\x40\x25\x77\xf4\x31\x0c\x7c\x7e\x01\x95\x1f\x3f\x18\x06\xa6\xfc\x60\x65\x80\x34\xa7\x06\xb2\x3c\xc5\x85\x9f\x30\xd0\xb7\x7e\x01\xd5\xb7\xa0\x78\x78\xc5\x80\xa8\x6f\x63\x19\x68\x5f\xdf\x8e\x82\x51\x80\x01\xfe\x03\xc1\x01\x22\xe8\x03\xec\x0f\x3f\x80\x69\x66\x08\x7d\x88\x11\x42\x1f\x3e\x00\xa5\x1f\xe0\xa2\x1f\x30\xa0\xd2\x68\xf2\x07\x50\xcd\x83\x99\x0f\xb3\x8f\x90\xbb\x00\x01\x00\x00\xff\xff\x5a\xa4\x61\x42\xbe\x0d\x00\x00")
-
-func faviconIcoBytes() ([]byte, error) {
-	return bindataRead(
-		_faviconIco,
-		"favicon.ico",
-	)
-}
-
-func faviconIco() (*asset, error) {
-	bytes, err := faviconIcoBytes()
-	if err != nil {
-		return nil, err
-	}
-
-	info := bindataFileInfo{name: "favicon.ico", size: 3518, mode: os.FileMode(436), modTime: time.Unix(1508916129, 0)}
-	a := &asset{bytes: bytes, info: info}
-	return a, nil
-}
-
-// Asset loads and returns the asset for the given name.
-// It returns an error if the asset could not be found or
-// could not be loaded.
-func Asset(name string) ([]byte, error) {
-	cannonicalName := strings.Replace(name, "\\", "/", -1)
-	if f, ok := _bindata[cannonicalName]; ok {
-		a, err := f()
-		if err != nil {
-			return nil, fmt.Errorf("Asset %s can't read by error: %v", name, err)
-		}
-		return a.bytes, nil
-	}
-	return nil, fmt.Errorf("Asset %s not found", name)
-}
-
-// MustAsset is like Asset but panics when Asset would return an error.
-// It simplifies safe initialization of global variables.
-func MustAsset(name string) []byte {
-	a, err := Asset(name)
-	if err != nil {
-		panic("asset: Asset(" + name + "): " + err.Error())
-	}
-
-	return a
-}
-
-// AssetInfo loads and returns the asset info for the given name.
-// It returns an error if the asset could not be found or
-// could not be loaded.
-func AssetInfo(name string) (os.FileInfo, error) {
-	cannonicalName := strings.Replace(name, "\\", "/", -1)
-	if f, ok := _bindata[cannonicalName]; ok {
-		a, err := f()
-		if err != nil {
-			return nil, fmt.Errorf("AssetInfo %s can't read by error: %v", name, err)
-		}
-		return a.info, nil
-	}
-	return nil, fmt.Errorf("AssetInfo %s not found", name)
-}
-
-// AssetNames returns the names of the assets.
-func AssetNames() []string {
-	names := make([]string, 0, len(_bindata))
-	for name := range _bindata {
-		names = append(names, name)
-	}
-	return names
-}
-
-// _bindata is a table, holding each asset generator, mapped to its name.
-var _bindata = map[string]func() (*asset, error){
-	"favicon.ico": faviconIco,
-}
-
-// AssetDir returns the file names below a certain
-// directory embedded in the file by go-bindata.
-// For example if you run go-bindata on data/... and data contains the
-// following hierarchy:
-//     data/
-//       foo.txt
-//       img/
-//         a.png
-//         b.png
-// then AssetDir("data") would return []string{"foo.txt", "img"}
-// AssetDir("data/img") would return []string{"a.png", "b.png"}
-// AssetDir("foo.txt") and AssetDir("notexist") would return an error
-// AssetDir("") will return []string{"data"}.
-func AssetDir(name string) ([]string, error) {
-	node := _bintree
-	if len(name) != 0 {
-		cannonicalName := strings.Replace(name, "\\", "/", -1)
-		pathList := strings.Split(cannonicalName, "/")
-		for _, p := range pathList {
-			node = node.Children[p]
-			if node == nil {
-				return nil, fmt.Errorf("Asset %s not found", name)
-			}
-		}
-	}
-	if node.Func != nil {
-		return nil, fmt.Errorf("Asset %s not found", name)
-	}
-	rv := make([]string, 0, len(node.Children))
-	for childName := range node.Children {
-		rv = append(rv, childName)
-	}
-	return rv, nil
-}
-
-type bintree struct {
-	Func     func() (*asset, error)
-	Children map[string]*bintree
-}
-
-var _bintree = &bintree{nil, map[string]*bintree{
-	"favicon.ico": &bintree{faviconIco, map[string]*bintree{}},
-}}
-
-// RestoreAsset restores an asset under the given directory
-func RestoreAsset(dir, name string) error {
-	data, err := Asset(name)
-	if err != nil {
-		return err
-	}
-	info, err := AssetInfo(name)
-	if err != nil {
-		return err
-	}
-	err = os.MkdirAll(_filePath(dir, filepath.Dir(name)), os.FileMode(0755))
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile(_filePath(dir, name), data, info.Mode())
-	if err != nil {
-		return err
-	}
-	err = os.Chtimes(_filePath(dir, name), info.ModTime(), info.ModTime())
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-// RestoreAssets restores an asset under the given directory recursively
-func RestoreAssets(dir, name string) error {
-	children, err := AssetDir(name)
-	// File
-	if err != nil {
-		return RestoreAsset(dir, name)
-	}
-	// Dir
-	for _, child := range children {
-		err = RestoreAssets(dir, filepath.Join(name, child))
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func _filePath(dir, name string) string {
-	cannonicalName := strings.Replace(name, "\\", "/", -1)
-	return filepath.Join(append([]string{dir}, strings.Split(cannonicalName, "/")...)...)
-}
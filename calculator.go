@@ -0,0 +1,175 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Databases whose series are exchange rates, quoted as units of the
+// foreign currency per US dollar
+var fxDatabases map[string]bool = map[string]bool{
+	"BOE": true,
+}
+
+// CalculatorInput describes a hypothetical investment.  Fees apply to
+// both the purchase and the sale, each as a flat amount plus a
+// percentage of the amount traded.
+type CalculatorInput struct {
+	Date       string
+	Amount     float64
+	FeePercent float64
+	FeeFlat    float64
+
+	// Local, for an exchange rate series, also reports what the
+	// dollars put in and taken out were worth in the foreign currency,
+	// each at that day's rate
+	Local bool
+}
+
+type CalculatorResult struct {
+	Selection DailySelection
+
+	Amount   float64
+	BuyFee   float64
+	SellFee  float64
+	Invested float64
+	Final    float64
+	Profit   float64
+	Percent  float64
+
+	// Only set for a Local calculation
+	Currency           string
+	AmountLocal        float64
+	FinalLocal         float64
+	LocalPercentChange float64
+}
+
+// ParseCalculatorInput reads an investment from request parameters,
+// using the configured defaults for anything left out.
+func ParseCalculatorInput(
+	config Config,
+	get func(string) string,
+) (CalculatorInput, error) {
+	in := CalculatorInput{
+		Date:       get("date"),
+		Amount:     config.Calculator.Amount,
+		FeePercent: config.Calculator.FeePercent,
+		FeeFlat:    config.Calculator.FeeFlat,
+		Local:      get("currency") == "local",
+	}
+
+	fields := []struct {
+		name string
+		dest *float64
+	}{
+		{"amount", &in.Amount},
+		{"fee_percent", &in.FeePercent},
+		{"fee_flat", &in.FeeFlat},
+	}
+	for _, v := range fields {
+		raw := get(v.name)
+		if raw == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f < 0 {
+			return in, fmt.Errorf("Invalid %s %q", v.name, raw)
+		}
+		*v.dest = f
+	}
+
+	if in.FeePercent > 100 {
+		return in, errors.New("Fee percentage can't be over 100")
+	}
+	if in.Date != "" {
+		if _, err := time.Parse(timeFormat, in.Date); err != nil {
+			return in, fmt.Errorf("Invalid date %q", in.Date)
+		}
+	}
+
+	return in, nil
+}
+
+// CalculatorSelection finds the pick an input refers to: the current
+// one, or an archived one from the history if a date is given.
+func CalculatorSelection(
+	config Config,
+	in CalculatorInput,
+	selection *SelectionStore,
+) (DailySelection, bool) {
+	if in.Date == "" {
+		current := selection.Load()
+		return current, !current.Time.IsZero()
+	}
+
+	history := LoadHistory(config.Cache, config.HistoryFile)
+	return history.On(in.Date, config.Schedule.Location)
+}
+
+func Calculate(
+	selection DailySelection,
+	in CalculatorInput,
+) (CalculatorResult, error) {
+	if selection.OldValue <= 0 || selection.NewValue <= 0 {
+		return CalculatorResult{}, errors.New("Selection has no usable values")
+	}
+
+	fee := func(amount float64) float64 {
+		return math.Min(amount, in.FeeFlat+amount*in.FeePercent/100)
+	}
+
+	out := CalculatorResult{Selection: selection, Amount: in.Amount}
+	out.BuyFee = fee(in.Amount)
+	out.Invested = in.Amount - out.BuyFee
+	gross := out.Invested * selection.NewValue / selection.OldValue
+	out.SellFee = fee(gross)
+	out.Final = gross - out.SellFee
+	out.Profit = out.Final - out.Amount
+	if out.Amount > 0 {
+		out.Percent = 100 * out.Profit / out.Amount
+	}
+
+	if in.Local {
+		if !fxDatabases[selection.Dataset.Database] {
+			return CalculatorResult{}, errors.New(
+				"Currency conversion is only available for exchange rates",
+			)
+		}
+		// The amounts stay in dollars, as above.  Someone counting in
+		// the foreign currency sees the dollars they put in at the
+		// starting rate and the dollars they took out at the final
+		// one, so the move in the rate shows up in their return on
+		// top of the pick's own.
+		out.Currency = selection.Dataset.Description
+		out.AmountLocal = out.Amount * selection.OldValue
+		out.FinalLocal = out.Final * selection.NewValue
+		if out.AmountLocal > 0 {
+			out.LocalPercentChange = 100 *
+				(out.FinalLocal - out.AmountLocal) / out.AmountLocal
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestCalculateLocal(t *testing.T) {
+	selection := testSelection(0)
+	selection.Dataset = Dataset{"BOE", "XUDLERD", "Euro"}
+	selection.OldValue, selection.NewValue = 100, 150
+
+	cases := []struct {
+		name        string
+		in          CalculatorInput
+		final       float64
+		percent     float64
+		local       float64
+		localChange float64
+	}{
+		{
+			"no fees",
+			CalculatorInput{Amount: 1000, Local: true},
+			1500,
+			50,
+			// 1500 dollars at 150 to the dollar, against 1000 at 100
+			225000,
+			125,
+		},
+		{
+			"fees",
+			CalculatorInput{Amount: 1000, FeeFlat: 10, Local: true},
+			// 990 invested grows to 1485, less 10 to sell
+			1475,
+			47.5,
+			221250,
+			121.25,
+		},
+	}
+
+	for _, c := range cases {
+		out, err := Calculate(selection, c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !closeTo(out.Final, c.final) || !closeTo(out.Percent, c.percent) {
+			t.Errorf(
+				"%s: final %v (%v%%), expected %v (%v%%)",
+				c.name,
+				out.Final,
+				out.Percent,
+				c.final,
+				c.percent,
+			)
+		}
+		if !closeTo(out.AmountLocal, 100*c.in.Amount) {
+			t.Errorf("%s: local amount %v", c.name, out.AmountLocal)
+		}
+		if !closeTo(out.FinalLocal, c.local) ||
+			!closeTo(out.LocalPercentChange, c.localChange) {
+			t.Errorf(
+				"%s: local final %v (%v%%), expected %v (%v%%)",
+				c.name,
+				out.FinalLocal,
+				out.LocalPercentChange,
+				c.local,
+				c.localChange,
+			)
+		}
+	}
+
+	// Without a move in the rate, the returns are the same
+	selection.NewValue = selection.OldValue
+	out, err := Calculate(selection, CalculatorInput{Amount: 1000, FeeFlat: 10, Local: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !closeTo(out.LocalPercentChange, out.Percent) {
+		t.Errorf("Flat rate gave %v%% and %v%% locally", out.Percent, out.LocalPercentChange)
+	}
+}
+
+func TestCalculateLocalNeedsRates(t *testing.T) {
+	_, err := Calculate(testSelection(0), CalculatorInput{Amount: 1, Local: true})
+	if err == nil {
+		t.Error("Expected an error converting a stock to local currency")
+	}
+}
@@ -0,0 +1,356 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TradingCalendar knows which days a market is open.  Days are
+// represented as midnight UTC, the same way GetRequest parses the
+// dates Quandl returns.
+type TradingCalendar struct {
+	Name string
+
+	weekend  map[time.Weekday]bool
+	rules    func(year int) []time.Time
+	holidays map[string]bool
+
+	lock  sync.Mutex
+	years map[int]map[string]bool
+}
+
+// Calendars holds every calendar by name, built in or loaded with
+// LoadCalendar.
+var Calendars map[string]*TradingCalendar = map[string]*TradingCalendar{
+	"NYSE": NewTradingCalendar("NYSE", nyseHolidays, nyseClosures),
+	"UK":   NewTradingCalendar("UK", ukHolidays, ukExtraHolidays),
+}
+
+// Closures for events rather than holidays, which no rule can predict
+var nyseClosures []string = []string{
+	"2001-09-11", "2001-09-12", "2001-09-13", "2001-09-14",
+	"2004-06-11",
+	"2007-01-02",
+	"2012-10-29", "2012-10-30",
+	"2018-12-05",
+	"2025-01-09",
+}
+
+var ukExtraHolidays []string = []string{
+	"1999-12-31",
+	"2002-06-03",
+	"2011-04-29",
+	"2012-06-05",
+	"2022-06-03",
+	"2022-09-19",
+	"2023-05-08",
+}
+
+func NewTradingCalendar(
+	name string,
+	rules func(year int) []time.Time,
+	holidays []string,
+) *TradingCalendar {
+	c := &TradingCalendar{
+		Name: name,
+		weekend: map[time.Weekday]bool{
+			time.Saturday: true,
+			time.Sunday:   true,
+		},
+		rules:    rules,
+		holidays: map[string]bool{},
+		years:    map[int]map[string]bool{},
+	}
+	for _, v := range holidays {
+		c.holidays[v] = true
+	}
+	return c
+}
+
+// LoadCalendar reads a custom calendar from a file and registers it
+// under the given name.  Each line holds a holiday as YYYY-MM-DD.  A
+// line of "extends NAME" also applies the rules of an existing
+// calendar, and "weekend DAY..." overrides the default of Saturday
+// and Sunday.  Anything after a # is a comment.
+func LoadCalendar(name, path string) error {
+	fin, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+
+	c := NewTradingCalendar(name, nil, nil)
+	scanner := bufio.NewScanner(fin)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		errorf := func(message string) error {
+			return fmt.Errorf("%s:%d: %s", path, lineNo, message)
+		}
+
+		switch fields[0] {
+		case "extends":
+			if len(fields) != 2 {
+				return errorf("extends takes one calendar name")
+			}
+			base, ok := Calendars[fields[1]]
+			if !ok {
+				return errorf("unknown calendar " + fields[1])
+			}
+			c.rules = base.rules
+			for k := range base.holidays {
+				c.holidays[k] = true
+			}
+
+		case "weekend":
+			c.weekend = map[time.Weekday]bool{}
+			for _, v := range fields[1:] {
+				name := strings.ToLower(v)
+				if len(name) > 3 {
+					name = name[:3]
+				}
+				day, ok := weekdayNames[name]
+				if !ok {
+					return errorf("unknown weekday " + v)
+				}
+				c.weekend[time.Weekday(day)] = true
+			}
+
+		default:
+			if _, err := time.Parse(timeFormat, fields[0]); err != nil {
+				return errorf("invalid date " + fields[0])
+			}
+			c.holidays[fields[0]] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// OnOrBefore would look for a trading day forever
+	if len(c.weekend) == 7 {
+		return fmt.Errorf("%s: every day of the week is a weekend", path)
+	}
+
+	Calendars[name] = c
+	return nil
+}
+
+// CalendarFor returns the calendar a database trades on.
+func CalendarFor(database string) (*TradingCalendar, bool) {
+	c, ok := Calendars[DataCalendars[database]]
+	return c, ok
+}
+
+func (c *TradingCalendar) IsTradingDay(t time.Time) bool {
+	if c.weekend[t.Weekday()] {
+		return false
+	}
+
+	day := t.Format(timeFormat)
+	if c.holidays[day] {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	year, ok := c.years[t.Year()]
+	if !ok {
+		year = map[string]bool{}
+		if c.rules != nil {
+			for _, v := range c.rules(t.Year()) {
+				year[v.Format(timeFormat)] = true
+			}
+		}
+		c.years[t.Year()] = year
+	}
+	return !year[day]
+}
+
+// OnOrBefore returns the last trading day no later than t's date.
+func (c *TradingCalendar) OnOrBefore(t time.Time) time.Time {
+	year, month, day := t.Date()
+	d := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	for !c.IsTradingDay(d) {
+		d = d.AddDate(0, 0, -1)
+	}
+	return d
+}
+
+// TradingDaysBetween counts the trading days after a, up to and
+// including b.
+func (c *TradingCalendar) TradingDaysBetween(a, b time.Time) int {
+	count := 0
+	for d := a.AddDate(0, 0, 1); !d.After(b); d = d.AddDate(0, 0, 1) {
+		if c.IsTradingDay(d) {
+			count++
+		}
+	}
+	return count
+}
+
+func nyseHolidays(year int) []time.Time {
+	out := []time.Time{
+		nthWeekday(year, time.January, time.Monday, 3),
+		nthWeekday(year, time.February, time.Monday, 3),
+		easter(year).AddDate(0, 0, -2),
+		lastWeekday(year, time.May, time.Monday),
+		observedNearest(date(year, time.July, 4)),
+		nthWeekday(year, time.September, time.Monday, 1),
+		nthWeekday(year, time.November, time.Thursday, 4),
+		observedNearest(date(year, time.December, 25)),
+	}
+
+	// New Year's Day falling on a Saturday isn't moved back into the
+	// previous year
+	if newYear := date(year, time.January, 1); newYear.Weekday() == time.Sunday {
+		out = append(out, newYear.AddDate(0, 0, 1))
+	} else if newYear.Weekday() != time.Saturday {
+		out = append(out, newYear)
+	}
+
+	if year >= 2022 {
+		out = append(out, observedNearest(date(year, time.June, 19)))
+	}
+
+	return out
+}
+
+func ukHolidays(year int) []time.Time {
+	easterSunday := easter(year)
+	out := []time.Time{
+		observedMonday(date(year, time.January, 1)),
+		easterSunday.AddDate(0, 0, -2),
+		easterSunday.AddDate(0, 0, 1),
+		lastWeekday(year, time.August, time.Monday),
+	}
+
+	// The early May holiday has twice been moved to VE Day
+	switch year {
+	case 1995, 2020:
+		out = append(out, date(year, time.May, 8))
+	default:
+		out = append(out, nthWeekday(year, time.May, time.Monday, 1))
+	}
+
+	// And the spring holiday moved for jubilees
+	switch year {
+	case 2002, 2012:
+		out = append(out, date(year, time.June, 4))
+	case 2022:
+		out = append(out, date(year, time.June, 2))
+	default:
+		out = append(out, lastWeekday(year, time.May, time.Monday))
+	}
+
+	// Christmas and Boxing Day substitutes both land after the 26th
+	christmas, boxing := date(year, time.December, 25), date(year, time.December, 26)
+	switch christmas.Weekday() {
+	case time.Friday:
+		out = append(out, christmas, date(year, time.December, 28))
+	case time.Saturday:
+		out = append(
+			out,
+			date(year, time.December, 27),
+			date(year, time.December, 28),
+		)
+	case time.Sunday:
+		out = append(out, boxing, date(year, time.December, 27))
+	default:
+		out = append(out, christmas, boxing)
+	}
+
+	return out
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func nthWeekday(
+	year int,
+	month time.Month,
+	weekday time.Weekday,
+	n int,
+) time.Time {
+	first := date(year, month, 1)
+	offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+	return first.AddDate(0, 0, offset+7*(n-1))
+}
+
+func lastWeekday(year int, month time.Month, weekday time.Weekday) time.Time {
+	last := date(year, month+1, 0)
+	offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+	return last.AddDate(0, 0, -offset)
+}
+
+// observedNearest moves a Saturday holiday to Friday and a Sunday one
+// to Monday.
+func observedNearest(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday:
+		return t.AddDate(0, 0, -1)
+	case time.Sunday:
+		return t.AddDate(0, 0, 1)
+	default:
+		return t
+	}
+}
+
+// observedMonday moves a weekend holiday to the following Monday.
+func observedMonday(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday:
+		return t.AddDate(0, 0, 2)
+	case time.Sunday:
+		return t.AddDate(0, 0, 1)
+	default:
+		return t
+	}
+}
+
+// easter finds Easter Sunday with the anonymous Gregorian algorithm.
+func easter(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return date(year, time.Month(month), day)
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closedWeekdays lists the weekdays in a year a calendar is closed.
+func closedWeekdays(c *TradingCalendar, year int) []string {
+	out := []string{}
+	for d := date(year, time.January, 1); d.Year() == year; d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday &&
+			!c.IsTradingDay(d) {
+			out = append(out, d.Format("01-02"))
+		}
+	}
+	return out
+}
+
+func TestCalendarHolidays(t *testing.T) {
+	cases := []struct {
+		calendar string
+		year     int
+		closed   string
+	}{
+		{"NYSE", 2019, "01-01 01-21 02-18 04-19 05-27 07-04 09-02 11-28 12-25"},
+		// Christmas on a Saturday moves to Friday, but New Year's Day
+		// 2022 doesn't move back into 2021, and there's no Juneteenth
+		// yet
+		{"NYSE", 2021, "01-01 01-18 02-15 04-02 05-31 07-05 09-06 11-25 12-24"},
+		{"NYSE", 2022, "01-17 02-21 04-15 05-30 06-20 07-04 09-05 11-24 12-26"},
+		{"NYSE", 2023, "01-02 01-16 02-20 04-07 05-29 06-19 07-04 09-04 11-23 12-25"},
+		{"NYSE", 2025, "01-01 01-09 01-20 02-17 04-18 05-26 06-19 07-04 09-01 11-27 12-25"},
+
+		{"UK", 2012, "01-02 04-06 04-09 05-07 06-04 06-05 08-27 12-25 12-26"},
+		{"UK", 2019, "01-01 04-19 04-22 05-06 05-27 08-26 12-25 12-26"},
+		// VE Day, and Boxing Day on a Saturday
+		{"UK", 2020, "01-01 04-10 04-13 05-08 05-25 08-31 12-25 12-28"},
+		// Christmas on a Saturday
+		{"UK", 2021, "01-01 04-02 04-05 05-03 05-31 08-30 12-27 12-28"},
+		// The Platinum Jubilee, the Queen's funeral and Christmas on
+		// a Sunday
+		{"UK", 2022, "01-03 04-15 04-18 05-02 06-02 06-03 08-29 09-19 12-26 12-27"},
+		{"UK", 2023, "01-02 04-07 04-10 05-01 05-08 05-29 08-28 12-25 12-26"},
+	}
+
+	for _, c := range cases {
+		closed := strings.Join(closedWeekdays(Calendars[c.calendar], c.year), " ")
+		if closed != c.closed {
+			t.Errorf(
+				"%s %d closed on\n%s\nexpected\n%s",
+				c.calendar,
+				c.year,
+				closed,
+				c.closed,
+			)
+		}
+	}
+}
+
+func TestEaster(t *testing.T) {
+	for _, expected := range []string{
+		"1818-03-22",
+		"1943-04-25",
+		"2019-04-21",
+		"2024-03-31",
+		"2038-04-25",
+	} {
+		year, _ := time.Parse(timeFormat, expected)
+		if got := easter(year.Year()).Format(timeFormat); got != expected {
+			t.Errorf("Expected Easter on %s, got %s", expected, got)
+		}
+	}
+}
+
+func TestOnOrBefore(t *testing.T) {
+	nyse := Calendars["NYSE"]
+	cases := []struct {
+		from     string
+		expected string
+	}{
+		{"2019-04-17", "2019-04-17"},
+		// Back over Easter weekend and Good Friday
+		{"2019-04-21", "2019-04-18"},
+		{"2019-04-22", "2019-04-22"},
+		{"2025-01-09", "2025-01-08"},
+	}
+	for _, c := range cases {
+		from, _ := time.Parse(timeFormat, c.from)
+		if got := nyse.OnOrBefore(from.Add(15 * time.Hour)).Format(timeFormat); got != c.expected {
+			t.Errorf("From %s, expected %s, got %s", c.from, c.expected, got)
+		}
+	}
+}
+
+func TestLoadCalendar(t *testing.T) {
+	dir := t.TempDir()
+	load := func(name, contents string) error {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			delete(Calendars, name)
+		})
+		return LoadCalendar(name, path)
+	}
+
+	err := load("TEST", `
+# A market closed on Fridays and Saturdays, with NYSE holidays
+extends NYSE
+weekend Friday sat
+2019-06-03  # A closure of its own
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Calendars["TEST"]
+	for day, open := range map[string]bool{
+		"2019-05-31": false, // Friday
+		"2019-06-01": false, // Saturday
+		"2019-06-02": true,  // Sunday
+		"2019-06-03": false, // Listed
+		"2019-06-04": true,
+		"2019-07-04": false, // From NYSE
+		"2001-09-11": false, // An NYSE closure
+	} {
+		d, _ := time.Parse(timeFormat, day)
+		if c.IsTradingDay(d) != open {
+			t.Errorf("Expected %s open to be %t", day, open)
+		}
+	}
+
+	for contents, message := range map[string]string{
+		"2019-13-01":                          "invalid date",
+		"extends NOWHERE":                     "unknown calendar",
+		"extends":                             "extends takes one",
+		"weekend funday":                      "unknown weekday",
+		"weekend mon tue wed thu fri sat sun": "every day",
+	} {
+		err := load("BAD", contents)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected %q loading %q, got %v", message, contents, err)
+		}
+		if _, ok := Calendars["BAD"]; ok {
+			t.Errorf("Registered a calendar from %q", contents)
+		}
+	}
+}
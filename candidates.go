@@ -0,0 +1,283 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CandidateList is the full ranked result of the most recent
+// selection run, best performer first.  Every run is also archived to
+// its own file, named by date, in the candidates directory.
+type CandidateList struct {
+	lock       sync.RWMutex
+	dir        string
+	time       time.Time
+	candidates []DailySelection
+}
+
+type candidateArchive struct {
+	Time       time.Time
+	Candidates []DailySelection
+}
+
+// RankedCandidate is a candidate along with its rank in the full list,
+// which it keeps when the list is filtered or re-sorted.
+type RankedCandidate struct {
+	Rank int
+	DailySelection
+}
+
+// LoadCandidateList starts from the most recent archived run.
+func LoadCandidateList(dir string) *CandidateList {
+	c := &CandidateList{dir: dir}
+	c.Reload()
+	return c
+}
+
+// Reload makes the most recent archived run current, for when
+// another process has written it.
+func (c *CandidateList) Reload() {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.gob"))
+	if err != nil || len(files) == 0 {
+		return
+	}
+	sort.Strings(files)
+	latest := strings.TrimSuffix(filepath.Base(files[len(files)-1]), ".gob")
+
+	t, list, err := c.Archived(latest)
+	if err != nil {
+		log.Println("Error reading candidate list:", err)
+		return
+	}
+
+	c.lock.Lock()
+	c.time, c.candidates = t, list
+	c.lock.Unlock()
+}
+
+func (c *CandidateList) Get() (time.Time, []DailySelection) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.time, c.candidates
+}
+
+// Store makes list the current candidates and archives it under the
+// date of t.
+func (c *CandidateList) Store(
+	config Config,
+	t time.Time,
+	list []DailySelection,
+) error {
+	c.lock.Lock()
+	c.time, c.candidates = t, list
+	c.lock.Unlock()
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+
+	// Written whole and renamed into place, so the API never reads
+	// half an archive
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(candidateArchive{t, list}); err != nil {
+		return err
+	}
+	date := t.In(config.Schedule.Location).Format(timeFormat)
+	return LocalStorage{Dir: c.dir}.Put(date+".gob", buf.Bytes())
+}
+
+// Archived reads the candidates from the run on a given date.
+func (c *CandidateList) Archived(
+	date string,
+) (time.Time, []DailySelection, error) {
+	// Checking the format also keeps the date from escaping the
+	// directory
+	if _, err := time.Parse(timeFormat, date); err != nil {
+		return time.Time{}, nil, fmt.Errorf("Invalid date %q", date)
+	}
+
+	fin, err := os.Open(filepath.Join(c.dir, date+".gob"))
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer fin.Close()
+
+	archive := candidateArchive{}
+	decoder := gob.NewDecoder(fin)
+	if err := decoder.Decode(&archive); err != nil {
+		return time.Time{}, nil, err
+	}
+	return archive.Time, archive.Candidates, nil
+}
+
+// Dates lists every archived run, most recent first.
+func (c *CandidateList) Dates() []string {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil
+	}
+
+	out := []string{}
+	for _, v := range files {
+		if strings.HasSuffix(v.Name(), ".gob") {
+			out = append(out, strings.TrimSuffix(v.Name(), ".gob"))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(out)))
+	return out
+}
+
+func RankCandidates(list []DailySelection) []RankedCandidate {
+	out := make([]RankedCandidate, len(list))
+	for i, v := range list {
+		out[i] = RankedCandidate{i + 1, v}
+	}
+	return out
+}
+
+type candidateLess func(a, b RankedCandidate) bool
+
+var candidateSorts map[string]candidateLess = map[string]candidateLess{
+	"rank": func(a, b RankedCandidate) bool {
+		return a.Rank < b.Rank
+	},
+	"change": func(a, b RankedCandidate) bool {
+		return a.Gain() < b.Gain()
+	},
+	"symbol": func(a, b RankedCandidate) bool {
+		return a.Dataset.Dataset < b.Dataset.Dataset
+	},
+	"description": func(a, b RankedCandidate) bool {
+		return a.Dataset.Description < b.Dataset.Description
+	},
+	"database": func(a, b RankedCandidate) bool {
+		if a.Dataset.Database != b.Dataset.Database {
+			return a.Dataset.Database < b.Dataset.Database
+		}
+		return a.Rank < b.Rank
+	},
+}
+
+// CandidateQuery is a view of a candidate list: which run, which
+// database to filter on (empty for all) and how to sort.
+type CandidateQuery struct {
+	Date       string
+	Database   string
+	Sort       string
+	Descending bool
+}
+
+func ParseCandidateQuery(get func(string) string) (CandidateQuery, error) {
+	q := CandidateQuery{
+		Date:       get("date"),
+		Database:   strings.ToUpper(get("database")),
+		Sort:       get("sort"),
+		Descending: get("order") == "desc",
+	}
+	if q.Sort == "" {
+		q.Sort = "rank"
+	}
+	if _, ok := candidateSorts[q.Sort]; !ok {
+		return q, fmt.Errorf("Unknown sort %q", q.Sort)
+	}
+	return q, nil
+}
+
+// Run applies the query, returning the time of the run it came from.
+func (q CandidateQuery) Run(
+	candidates *CandidateList,
+) (time.Time, []RankedCandidate, error) {
+	t, list := candidates.Get()
+	if q.Date != "" {
+		var err error
+		t, list, err = candidates.Archived(q.Date)
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+	}
+
+	ranked := RankCandidates(list)
+	if q.Database != "" {
+		filtered := []RankedCandidate{}
+		for _, v := range ranked {
+			if v.Dataset.Database == q.Database {
+				filtered = append(filtered, v)
+			}
+		}
+		ranked = filtered
+	}
+
+	less := candidateSorts[q.Sort]
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if q.Descending {
+			return less(ranked[j], ranked[i])
+		}
+		return less(ranked[i], ranked[j])
+	})
+
+	return t, ranked, nil
+}
+
+type candidateJSON struct {
+	Rank          int       `json:"rank"`
+	Database      string    `json:"database"`
+	Dataset       string    `json:"dataset"`
+	Description   string    `json:"description"`
+	OldValue      float64   `json:"old_value"`
+	NewValue      float64   `json:"new_value"`
+	OldTime       time.Time `json:"old_time"`
+	NewTime       time.Time `json:"new_time"`
+	PercentChange float64   `json:"percent_change"`
+}
+
+func candidateResponse(
+	t time.Time,
+	list []RankedCandidate,
+) map[string]interface{} {
+	out := make([]candidateJSON, len(list))
+	for i, v := range list {
+		out[i] = candidateJSON{
+			Rank:          v.Rank,
+			Database:      v.Dataset.Database,
+			Dataset:       v.Dataset.Dataset,
+			Description:   v.Dataset.Description,
+			OldValue:      v.OldValue,
+			NewValue:      v.NewValue,
+			OldTime:       v.OldTime,
+			NewTime:       v.NewTime,
+			PercentChange: 100 * v.Gain(),
+		}
+	}
+	return map[string]interface{}{
+		"time":       t,
+		"candidates": out,
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sync"
+	"time"
+)
+
+const chartWidth = 600
+const chartHeight = 260
+
+// Room above and below the plot for the point annotations
+const chartMargin = 40
+
+// ChartCache holds the chart for the current selection in each
+// locale, so it's only drawn once per pick and language.
+type ChartCache struct {
+	lock sync.Mutex
+	key  string
+	svgs map[string]template.HTML
+}
+
+func (c *ChartCache) For(locale *Locale, selection DailySelection) template.HTML {
+	key := selection.Dataset.Key() + "@" + selection.Time.String()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.key != key {
+		c.key, c.svgs = key, map[string]template.HTML{}
+	}
+	svg, ok := c.svgs[locale.Tag]
+	if !ok {
+		svg = RenderChart(locale, selection.Series)
+		c.svgs[locale.Tag] = svg
+	}
+	return svg
+}
+
+// RenderChart draws a series as an inline SVG line chart with its
+// first and last points labeled in the locale's formats.  It returns
+// nothing for a series too short to draw.
+func RenderChart(locale *Locale, series []SeriesPoint) template.HTML {
+	if len(series) < 2 {
+		return ""
+	}
+
+	minValue, maxValue := series[0].Value, series[0].Value
+	for _, v := range series {
+		if v.Value < minValue {
+			minValue = v.Value
+		}
+		if v.Value > maxValue {
+			maxValue = v.Value
+		}
+	}
+	if minValue == maxValue {
+		minValue, maxValue = minValue-1, maxValue+1
+	}
+
+	start, end := series[0].Time, series[len(series)-1].Time
+	span := end.Sub(start)
+	if span <= 0 {
+		span = time.Nanosecond
+	}
+
+	x := func(t time.Time) float64 {
+		return chartMargin + float64(t.Sub(start))/float64(span)*
+			(chartWidth-2*chartMargin)
+	}
+	y := func(v float64) float64 {
+		return chartHeight - chartMargin - (v-minValue)/(maxValue-minValue)*
+			(chartHeight-2*chartMargin)
+	}
+
+	out := &bytes.Buffer{}
+	fmt.Fprintf(
+		out,
+		`<svg xmlns="http://www.w3.org/2000/svg" class="chart" `+
+			`viewBox="0 0 %d %d" width="100%%" role="img" `+
+			`aria-label="%s">`,
+		chartWidth,
+		chartHeight,
+		template.HTMLEscapeString(
+			locale.Text("index.chart", locale.Date(start), locale.Date(end)),
+		),
+	)
+
+	fmt.Fprintf(
+		out,
+		`<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999" />`,
+		chartMargin, chartHeight-chartMargin,
+		chartWidth-chartMargin, chartHeight-chartMargin,
+	)
+
+	out.WriteString(
+		`<polyline fill="none" stroke="#2a6" stroke-width="2" points="`,
+	)
+	for i, v := range series {
+		if i > 0 {
+			out.WriteString(" ")
+		}
+		fmt.Fprintf(out, "%.1f,%.1f", x(v.Time), y(v.Value))
+	}
+	out.WriteString(`" />`)
+
+	annotate := func(p SeriesPoint, anchor string) {
+		px, py := x(p.Time), y(p.Value)
+
+		// Labels go on whichever side of the point has more room
+		labelY, dateY := py-22, py-8
+		if py < chartHeight/2 {
+			labelY, dateY = py+20, py+34
+		}
+
+		fmt.Fprintf(
+			out,
+			`<circle cx="%.1f" cy="%.1f" r="4" fill="#2a6" />`+
+				`<text x="%.1f" y="%.1f" text-anchor="%s" font-size="14" `+
+				`font-weight="bold">%s</text>`+
+				`<text x="%.1f" y="%.1f" text-anchor="%s" font-size="12" `+
+				`fill="#555">%s</text>`,
+			px, py,
+			px, labelY, anchor, template.HTMLEscapeString(locale.Number(p.Value, 2)),
+			px, dateY, anchor, template.HTMLEscapeString(locale.Date(p.Time)),
+		)
+	}
+	annotate(series[0], "start")
+	annotate(series[len(series)-1], "end")
+
+	out.WriteString(`</svg>`)
+	return template.HTML(out.String())
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderChartLocalized(t *testing.T) {
+	selection := testSelection(0)
+	selection.Series[1].Value = 1234.5
+
+	cases := []struct {
+		tag      string
+		expected []string
+	}{
+		{"en", []string{`aria-label="Value from `, ">1.00<", ">1,234.50<"}},
+		{"de", []string{`aria-label="Wert vom `, ">1,00<", ">1.234,50<"}},
+		{"fr", []string{`aria-label="Valeur du `, ">1,00<"}},
+	}
+
+	charts := &ChartCache{}
+	for _, c := range cases {
+		locale := Locales[c.tag]
+		svg := string(charts.For(locale, selection))
+		expected := append(
+			c.expected,
+			locale.Date(selection.Series[0].Time),
+			locale.Date(selection.Series[1].Time),
+		)
+		for _, v := range expected {
+			if !strings.Contains(svg, v) {
+				t.Errorf("%s chart is missing %q", c.tag, v)
+			}
+		}
+		if c.tag != "en" && strings.Contains(svg, "Jun 1, 2016") {
+			t.Errorf("%s chart has English dates", c.tag)
+		}
+	}
+
+	// Each language keeps its own chart
+	en := charts.For(Locales["en"], selection)
+	if en == charts.For(Locales["de"], selection) {
+		t.Error("English and German charts are the same")
+	}
+	if en != RenderChart(Locales["en"], selection.Series) {
+		t.Error("Cached English chart is out of date")
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"time"
+)
+
+// Clock is where code that waits, or depends on what time it is,
+// gets the time from, so tests can substitute a fake one.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// SystemClock is the real time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// ShiftClock returns a clock that reads at from now and runs at the same
+// rate as base from then on.  Waits on it still take real time, so
+// rate limits hold while replaying the past.
+func ShiftClock(base Clock, at time.Time) Clock {
+	return shiftedClock{base: base, offset: at.Sub(base.Now())}
+}
+
+type shiftedClock struct {
+	base   Clock
+	offset time.Duration
+}
+
+func (c shiftedClock) Now() time.Time {
+	return c.base.Now().Add(c.offset)
+}
+
+func (c shiftedClock) Sleep(d time.Duration) {
+	c.base.Sleep(d)
+}
@@ -20,20 +20,31 @@
 package main
 
 import (
+	"sync"
 	"time"
 )
 
-// How far into the day to refresh (using an offset to make sure we
-// don't have any doubt about what day it is and DST doesn't ruin
-// everything)
-const dayStartOffset = time.Hour * 2
+// fakeClock only moves when something sleeps on it, so waits take no
+// real time and always come out the same.
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func newFakeClock(t time.Time) *fakeClock {
+	return &fakeClock{now: t}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
 
-func NextLoadTime(t time.Time) time.Time {
-	// I know, strictly speaking, this has some edge cases.  Hopefully
-	// none of them blow up horifically
-	someTimeTomorrow := t.AddDate(0, 0, 1)
-	year, month, day := someTimeTomorrow.Date()
-	return time.
-		Date(year, month, day, 0, 0, 0, 0, t.Location()).
-		Add(dayStartOffset)
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
 }
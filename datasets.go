@@ -29,6 +29,21 @@ type Dataset struct {
 	Description string
 }
 
+// Key identifies a dataset independent of its description.
+func (d Dataset) Key() string {
+	return d.Database + "/" + d.Dataset
+}
+
+// FindDataset looks up a dataset by its Key.
+func FindDataset(key string) (Dataset, bool) {
+	for _, v := range Datasets {
+		if v.Key() == key {
+			return v, true
+		}
+	}
+	return Dataset{}, false
+}
+
 // Limits defines the maximum number of requests per a given duration
 var Limits map[time.Duration]int = map[time.Duration]int{
 	10 * time.Second: 200,
@@ -44,6 +59,13 @@ var DataColumns map[string]int = map[string]int{
 	"BOE":  1,
 }
 
+// DataCalendars names the trading calendar each database follows.
+var DataCalendars map[string]string = map[string]string{
+	"WIKI": "NYSE",
+	"CME":  "NYSE",
+	"BOE":  "UK",
+}
+
 var Datasets []Dataset = []Dataset{
 	Dataset{"WIKI", "AAPL", "Apple Inc (AAPL)"},
 	Dataset{"WIKI", "AA", "Alcoa Inc. (AA)"},
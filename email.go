@@ -0,0 +1,378 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The built-in email bodies, used for anything not overridden in the
+// email subdirectory of the template directory.  They get the same
+// data as the index page, with absolute permalinks, and the weekly
+// ones get a list of those as .picks.
+var defaultEmailTemplates map[string]string = map[string]string{
+	"daily.txt": `{{.l.Text "email.daily_heading" .site.Title}}
+
+{{.l.Text "feed.entry_summary" .description .symbol .percent_increase .old_time .new_time}}
+
+{{.permalink}}
+`,
+
+	"daily.html": `<!DOCTYPE HTML>
+<html lang="{{.l.Tag}}">
+	<body>
+		<h1>{{.site.Title}}</h1>
+		<p>{{.l.T "index.pick" .description}}</p>
+		<p>
+			{{if .calendar}}
+			{{.l.T "index.change_calendar" .old_time .new_time .symbol .percent_increase .trading_days .calendar}}
+			{{else}}
+			{{.l.T "index.change" .old_time .new_time .symbol .percent_increase}}
+			{{end}}
+		</p>
+		<p><a href="{{.permalink}}">{{.l.T "index.permalink"}}</a></p>
+	</body>
+</html>
+`,
+
+	"weekly.txt": `{{.l.Text "email.weekly_heading" .site.Title}}
+{{range .picks}}
+{{.date}}: {{.l.Text "feed.entry_summary" .description .symbol .percent_increase .old_time .new_time}}
+{{.permalink}}
+{{end}}`,
+
+	"weekly.html": `<!DOCTYPE HTML>
+<html lang="{{.l.Tag}}">
+	<body>
+		<h1>{{.l.T "email.weekly_heading" .site.Title}}</h1>
+		{{range .picks}}
+		<h2><a href="{{.permalink}}">{{.date}}</a></h2>
+		<p>{{.l.T "index.change" .old_time .new_time .description .percent_increase}}</p>
+		{{end}}
+	</body>
+</html>
+`,
+}
+
+// EmailConfig is how and where digests are sent.
+type EmailConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// EmailNotifier mails each new pick, or with Weekly set, a summary of
+// the past seven picks on one day of the week.
+type EmailNotifier struct {
+	config  Config
+	email   EmailConfig
+	locale  *Locale
+	Weekly  bool
+	Weekday time.Weekday
+
+	text map[string]*template.Template
+	html map[string]*htmltemplate.Template
+}
+
+func NewEmailNotifier(
+	config Config,
+	email EmailConfig,
+	locale *Locale,
+	templateDir string,
+) (*EmailNotifier, error) {
+	if len(email.To) == 0 {
+		return nil, fmt.Errorf("No email recipients configured")
+	}
+	if _, err := emailAddress(email.From); err != nil {
+		return nil, err
+	}
+
+	n := &EmailNotifier{
+		config: config,
+		email:  email,
+		locale: locale,
+		text:   map[string]*template.Template{},
+		html:   map[string]*htmltemplate.Template{},
+	}
+
+	for _, name := range []string{"daily", "weekly"} {
+		text, err := readEmailTemplate(templateDir, name+".txt")
+		if err != nil {
+			return nil, err
+		}
+		n.text[name], err = template.New(name).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+
+		html, err := readEmailTemplate(templateDir, name+".html")
+		if err != nil {
+			return nil, err
+		}
+		n.html[name], err = htmltemplate.New(name).Parse(html)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// NewEmailNotifiers sets up the digests asked for: "daily", "weekly"
+// (sent on weekday) or "both".
+func NewEmailNotifiers(
+	config Config,
+	email EmailConfig,
+	digest string,
+	weekday string,
+	locale *Locale,
+	templateDir string,
+) ([]Notifier, error) {
+	day := -1
+	for i := time.Sunday; i <= time.Saturday; i++ {
+		if strings.EqualFold(weekday, i.String()) {
+			day = int(i)
+		}
+	}
+	if day < 0 {
+		return nil, fmt.Errorf("Invalid email weekday %q", weekday)
+	}
+
+	daily, weekly := false, false
+	switch digest {
+	case "daily":
+		daily = true
+	case "weekly":
+		weekly = true
+	case "both":
+		daily, weekly = true, true
+	default:
+		return nil, fmt.Errorf("Unknown email digest %q", digest)
+	}
+
+	out := []Notifier{}
+	if daily {
+		n, err := NewEmailNotifier(config, email, locale, templateDir)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	if weekly {
+		n, err := NewEmailNotifier(config, email, locale, templateDir)
+		if err != nil {
+			return nil, err
+		}
+		n.Weekly, n.Weekday = true, time.Weekday(day)
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func readEmailTemplate(dir, name string) (string, error) {
+	if dir != "" {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "email", name))
+		if err == nil {
+			return string(data), nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return defaultEmailTemplates[name], nil
+}
+
+func (n *EmailNotifier) Name() string {
+	if n.Weekly {
+		return "weekly email"
+	}
+	return "daily email"
+}
+
+func (n *EmailNotifier) Notify(selection DailySelection) error {
+	location := n.config.Schedule.Location
+	data := n.pickData(selection)
+
+	if !n.Weekly {
+		subject := n.locale.Text(
+			"email.daily_subject",
+			n.config.Site.Title,
+			selection.Dataset.Description,
+		)
+		return n.send(subject, "daily", data)
+	}
+
+	if selection.Time.In(location).Weekday() != n.Weekday {
+		return nil
+	}
+
+	// The new pick may not have made it into the history file yet
+	history := append(LoadHistory(n.config.Cache, n.config.HistoryFile), selection)
+	picks := []map[string]interface{}{}
+	for _, v := range history.Recent(7, selection.Time, location) {
+		picks = append(picks, n.pickData(v))
+	}
+	data["picks"] = picks
+
+	subject := n.locale.Text("email.weekly_subject", n.config.Site.Title)
+	return n.send(subject, "weekly", data)
+}
+
+func (n *EmailNotifier) pickData(
+	selection DailySelection,
+) map[string]interface{} {
+	data := SelectionData(n.config, n.locale, selection, "")
+	data["permalink"] = strings.TrimSuffix(n.config.Site.URL, "/") +
+		data["permalink"].(string)
+	data["date"] = n.locale.Date(selection.Time.In(n.config.Schedule.Location))
+	data["site"] = n.config.Site
+	data["l"] = n.locale
+	return data
+}
+
+func (n *EmailNotifier) send(
+	subject string,
+	name string,
+	data map[string]interface{},
+) error {
+	message, err := n.Message(subject, name, data)
+	if err != nil {
+		return PermanentError{err}
+	}
+
+	from, _ := emailAddress(n.email.From)
+	var auth smtp.Auth
+	if n.email.Username != "" {
+		auth = smtp.PlainAuth(
+			"",
+			n.email.Username,
+			n.email.Password,
+			n.email.Host,
+		)
+	}
+
+	return smtp.SendMail(
+		net.JoinHostPort(n.email.Host, fmt.Sprint(n.email.Port)),
+		auth,
+		from,
+		n.email.To,
+		message,
+	)
+}
+
+// Message builds a multipart/alternative email with plain text and
+// HTML bodies.  Recipients only appear in the envelope, so they can't
+// see each other's addresses.
+func (n *EmailNotifier) Message(
+	subject string,
+	name string,
+	data map[string]interface{},
+) ([]byte, error) {
+	text, html := bytes.Buffer{}, bytes.Buffer{}
+	if err := n.text[name].Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := n.html[name].Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	from, _ := emailAddress(n.email.From)
+	domain := from[strings.LastIndex(from, "@")+1:]
+
+	out := bytes.Buffer{}
+	body := multipart.NewWriter(&out)
+	headers := []string{
+		"From: " + n.email.From,
+		"To: undisclosed-recipients:;",
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + n.config.Clock.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + hex.EncodeToString(id) + "@" + domain + ">",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + body.Boundary(),
+	}
+	out.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content []byte) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write(content); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// emailAddress extracts the bare address from a From header value
+// like "Daily Hindsight <picks@example.com>".
+func emailAddress(from string) (string, error) {
+	if i := strings.LastIndex(from, "<"); i >= 0 {
+		from = strings.TrimSuffix(from[i+1:], ">")
+	}
+	if !strings.Contains(from, "@") {
+		return "", fmt.Errorf("Invalid email sender %q", from)
+	}
+	return from, nil
+}
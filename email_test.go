@@ -0,0 +1,228 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStandIn accepts a single message over SMTP, just enough of the
+// protocol for smtp.SendMail.
+type smtpStandIn struct {
+	listener net.Listener
+	from     string
+	to       []string
+	data     []byte
+	done     chan struct{}
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{listener: listener, done: make(chan struct{})}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		defer close(s.done)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(textproto.NewConn(conn))
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn *textproto.Conn) {
+	conn.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			conn.PrintfLine("250-localhost")
+			conn.PrintfLine("250 8BITMIME")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			// SendMail adds BODY=8BITMIME since the stand-in offers it
+			from := strings.Fields(line[len("MAIL FROM:"):])[0]
+			s.from = strings.Trim(from, "<>")
+			conn.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.to = append(s.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			conn.PrintfLine("250 OK")
+		case command == "DATA":
+			conn.PrintfLine("354 Go ahead")
+			// ReadDotBytes undoes the dot stuffing and turns CRLF
+			// into LF
+			s.data, err = conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			conn.PrintfLine("250 OK")
+		case command == "QUIT":
+			conn.PrintfLine("221 Bye")
+			return
+		default:
+			conn.PrintfLine("250 OK")
+		}
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	server := newSMTPStandIn(t)
+	host, port, err := net.SplitHostPort(server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, _ := strconv.Atoi(port)
+
+	config := testConfig(t)
+	config.Site.URL = "https://hindsight.test"
+	config.Clock = newFakeClock(time.Date(2017, 6, 1, 2, 0, 10, 0, time.UTC))
+	notifier, err := NewEmailNotifier(
+		config,
+		EmailConfig{
+			Host: host,
+			Port: portNumber,
+			From: "Hindsight <picks@hindsight.test>",
+			To:   []string{"one@example.com", "two@example.com"},
+		},
+		Locales["fr"],
+		"",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.Notify(testSelection(0)); err != nil {
+		t.Fatal(err)
+	}
+	<-server.done
+
+	if server.from != "picks@hindsight.test" {
+		t.Errorf("Envelope from %q", server.from)
+	}
+	if strings.Join(server.to, " ") != "one@example.com two@example.com" {
+		t.Errorf("Envelope to %v", server.to)
+	}
+
+	message, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(server.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to := message.Header.Get("To"); to != "undisclosed-recipients:;" {
+		t.Errorf("Recipients exposed in To: %q", to)
+	}
+	if message.Header.Get("MIME-Version") != "1.0" ||
+		message.Header.Get("Message-ID") == "" {
+		t.Errorf("Missing headers in %v", message.Header)
+	}
+	if date := message.Header.Get("Date"); date != "Thu, 01 Jun 2017 02:00:10 +0000" {
+		t.Errorf("Expected the date from the clock, got %q", date)
+	}
+
+	// The French subject has a no-break space, so it must be encoded
+	rawSubject := message.Header.Get("Subject")
+	if !strings.HasPrefix(rawSubject, "=?utf-8?q?") {
+		t.Errorf("Subject isn't encoded: %q", rawSubject)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(rawSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Test Hindsight : Test dataset" {
+		t.Errorf("Wrong subject %q", subject)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %s", mediaType)
+	}
+
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	phrases := map[string]string{
+		"text/plain": "La sélection du jour de Test Hindsight",
+		"text/html":  "L'investissement rétrospectif du jour",
+	}
+	for _, expected := range []string{"text/plain", "text/html"} {
+		// NextRawPart leaves the encoding alone, so it can be checked
+		part, err := parts.NextRawPart()
+		if err != nil {
+			t.Fatalf("Missing %s part: %s", expected, err)
+		}
+		if ct := part.Header.Get("Content-Type"); ct != expected+"; charset=utf-8" {
+			t.Errorf("Expected %s, got %s", expected, ct)
+		}
+		if cte := part.Header.Get("Content-Transfer-Encoding"); cte != "quoted-printable" {
+			t.Errorf("%s part is %s", expected, cte)
+		}
+
+		raw, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(raw, []byte("=C3=A9")) {
+			t.Errorf("%s part doesn't encode é as quoted-printable", expected)
+		}
+		for _, line := range strings.Split(string(raw), "\n") {
+			if len(line) > 76 {
+				t.Errorf("%s part has a %d character line", expected, len(line))
+				break
+			}
+		}
+
+		decoded, err := ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(raw)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if phrase := phrases[expected]; !strings.Contains(string(decoded), phrase) {
+			t.Errorf("%s part is missing %q", expected, phrase)
+		}
+		if !strings.Contains(string(decoded), "https://hindsight.test/pick/2017-06-01") {
+			t.Errorf("%s part is missing the permalink", expected)
+		}
+	}
+	if _, err := parts.NextRawPart(); err == nil {
+		t.Error("Expected only two parts")
+	}
+}
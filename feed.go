@@ -0,0 +1,293 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const feedEntries = 30
+
+// FeedEntry is one day's pick as it appears in the feeds.
+type FeedEntry struct {
+	ID        string
+	Date      string
+	Permalink string
+	Title     string
+	Summary   string
+	Time      time.Time
+}
+
+// FeedEntries returns the most recent picks from the history, newest
+// first.  Only the last pick on each date is included, so a manual
+// refresh replaces that day's entry instead of adding another one.
+func FeedEntries(
+	config Config,
+	locale *Locale,
+	history SelectionHistory,
+	base string,
+	max int,
+) []FeedEntry {
+	out := []FeedEntry{}
+	seen := map[string]bool{}
+	for i := len(history) - 1; i >= 0 && len(out) < max; i-- {
+		v := history[i]
+		date := v.Time.In(config.Schedule.Location).Format(timeFormat)
+		if seen[date] {
+			continue
+		}
+		seen[date] = true
+
+		percent := locale.Percent(100*v.Gain(), 0)
+		out = append(out, FeedEntry{
+			ID:        FeedID(config, date),
+			Date:      date,
+			Permalink: base + PermalinkPath(date),
+			Title:     locale.Text("feed.entry_title", v.Dataset.Description, percent),
+			Summary: locale.Text(
+				"feed.entry_summary",
+				v.Dataset.Description,
+				v.Dataset.Key(),
+				percent,
+				locale.Date(v.OldTime),
+				locale.Date(v.NewTime),
+			),
+			Time: v.Time,
+		})
+	}
+	return out
+}
+
+// FeedID is a tag URI (RFC 4151) naming the feed, or the pick on a
+// date if one is given.  Unlike the permalink it doesn't depend on
+// the host or scheme a reader came in through, so the same entry
+// never shows up twice.
+func FeedID(config Config, date string) string {
+	authority := config.Site.ContactEmail
+	if authority == "" {
+		authority = "dailyhindsight"
+	}
+	if date == "" {
+		return "tag:" + authority + ",2017:feed"
+	}
+	return "tag:" + authority + "," + date + ":pick"
+}
+
+func PermalinkPath(date string) string {
+	return "/pick/" + date
+}
+
+// BaseURL is the configured site URL, or failing that the one the
+// request was made to.
+func BaseURL(config Config, r *http.Request) string {
+	if config.Site.URL != "" {
+		return strings.TrimSuffix(config.Site.URL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Lang    string      `xml:"xml:lang,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		Language      string    `xml:"language"`
+		LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+		Items         []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+// FeedHandler serves the pick history as an Atom or RSS feed.  It
+// reads the history on every request, so a new pick shows up as soon
+// as SelectSynchronously has stored it.
+func FeedHandler(config Config, format string) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			locale := NegotiateLocale(w, r)
+			base := BaseURL(config, r)
+			entries := FeedEntries(
+				config,
+				locale,
+				LoadHistory(config.Cache, config.HistoryFile),
+				base,
+				feedEntries,
+			)
+
+			updated := time.Time{}
+			if len(entries) > 0 {
+				updated = entries[0].Time
+			}
+
+			var feed interface{}
+			contentType := ""
+			switch format {
+			case "atom":
+				contentType = "application/atom+xml; charset=utf-8"
+				atom := atomFeed{
+					Lang:    locale.Tag,
+					Title:   config.Site.Title,
+					ID:      FeedID(config, ""),
+					Updated: updated.UTC().Format(time.RFC3339),
+					Links: []atomLink{
+						{"self", "application/atom+xml", base + "/feed.atom"},
+						{"alternate", "text/html", base + "/"},
+					},
+				}
+				for _, v := range entries {
+					atom.Entries = append(atom.Entries, atomEntry{
+						Title:   v.Title,
+						ID:      v.ID,
+						Updated: v.Time.UTC().Format(time.RFC3339),
+						Link:    atomLink{"alternate", "text/html", v.Permalink},
+						Summary: v.Summary,
+					})
+				}
+				feed = atom
+
+			case "rss":
+				contentType = "application/rss+xml; charset=utf-8"
+				rss := rssFeed{Version: "2.0"}
+				rss.Channel.Title = config.Site.Title
+				rss.Channel.Link = base + "/"
+				rss.Channel.Description = locale.Text("feed.description")
+				rss.Channel.Language = locale.Tag
+				if !updated.IsZero() {
+					rss.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+				}
+				for _, v := range entries {
+					rss.Channel.Items = append(rss.Channel.Items, rssItem{
+						Title:       v.Title,
+						Link:        v.Permalink,
+						GUID:        rssGUID{false, v.ID},
+						PubDate:     v.Time.UTC().Format(time.RFC1123Z),
+						Description: v.Summary,
+					})
+				}
+				feed = rss
+
+			default:
+				panic("Unknown feed format " + format)
+			}
+
+			buf := bytes.Buffer{}
+			buf.WriteString(xml.Header)
+			encoder := xml.NewEncoder(&buf)
+			encoder.Indent("", "\t")
+			if err := encoder.Encode(feed); err != nil {
+				panic(err)
+			}
+
+			// ServeContent takes care of If-Modified-Since for pollers
+			w.Header().Set("Content-Type", contentType)
+			http.ServeContent(w, r, "", updated, bytes.NewReader(buf.Bytes()))
+		},
+	)
+}
+
+// PickHandler renders the index page for a past day's pick, at the
+// permalink the feeds point to.
+func PickHandler(config Config) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			date := strings.TrimPrefix(r.URL.Path, PermalinkPath(""))
+			if _, err := time.Parse(timeFormat, date); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+
+			history := LoadHistory(config.Cache, config.HistoryFile)
+			pick, ok := history.On(date, config.Schedule.Location)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+
+			// Older histories still have the series inline
+			series := pick.Series
+			if len(series) == 0 {
+				var err error
+				series, err = LoadSeries(config.Cache, config.TempFile, date)
+				if err != nil && !os.IsNotExist(err) {
+					log.Println("Error reading selection series:", err)
+				}
+			}
+
+			locale := NegotiateLocale(w, r)
+			data := SelectionData(config, locale, pick, RenderChart(locale, series))
+			data["date"] = locale.Date(pick.Time.In(config.Schedule.Location))
+			ShareData(config, BaseURL(config, r), locale, pick, data)
+
+			err := config.Templates.Render(w, locale, "index", data)
+			if err != nil {
+				panic(err)
+			}
+		},
+	)
+}
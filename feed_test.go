@@ -0,0 +1,79 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFeedIDsIgnoreHost(t *testing.T) {
+	config := testConfig(t)
+	config.Site.ContactEmail = "feeds@example.com"
+	history := SelectionHistory{testSelection(0), testSelection(1)}
+	if err := SaveHistory(config.Cache, config.HistoryFile, history); err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(format, url string, secure bool) string {
+		r := httptest.NewRequest("GET", url, nil)
+		if secure {
+			r.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		FeedHandler(config, format).ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	atomIDs := func(body string) []string {
+		feed := atomFeed{}
+		if err := xml.Unmarshal([]byte(body), &feed); err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{feed.ID}
+		for _, v := range feed.Entries {
+			ids = append(ids, v.ID)
+		}
+		return ids
+	}
+	plain := atomIDs(fetch("atom", "http://one.test/feed.atom", false))
+	secure := atomIDs(fetch("atom", "https://two.test/feed.atom", true))
+	if strings.Join(plain, " ") != strings.Join(secure, " ") {
+		t.Errorf("IDs differ by host: %v and %v", plain, secure)
+	}
+
+	expected := []string{
+		"tag:feeds@example.com,2017:feed",
+		"tag:feeds@example.com,2017-06-02:pick",
+		"tag:feeds@example.com,2017-06-01:pick",
+	}
+	if strings.Join(plain, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected IDs %v, got %v", expected, plain)
+	}
+
+	rss := fetch("rss", "http://one.test/feed.rss", false)
+	guid := `<guid isPermaLink="false">tag:feeds@example.com,2017-06-02:pick</guid>`
+	if !strings.Contains(rss, guid) {
+		t.Errorf("Expected %s in the RSS feed", guid)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// SelectionHistory is every past pick, oldest first.
+type SelectionHistory []DailySelection
+
+func WriteHistory(fout io.Writer, history SelectionHistory) error {
+	encoder := gob.NewEncoder(fout)
+	return encoder.Encode(history)
+}
+
+func ReadHistory(fin io.Reader) (SelectionHistory, error) {
+	out := SelectionHistory{}
+	decoder := gob.NewDecoder(fin)
+	err := decoder.Decode(&out)
+	if err != nil {
+		return SelectionHistory{}, err
+	}
+	return out, nil
+}
+
+// LoadHistory reads the history from storage, treating a missing or
+// corrupt one as empty so a bad blob can't stop the daily pick.
+func LoadHistory(store Storage, name string) SelectionHistory {
+	history, err := readHistory(store, name)
+	if err != nil {
+		log.Println("Error reading history:", err)
+		return SelectionHistory{}
+	}
+	return history
+}
+
+// readHistory is LoadHistory without the forgiveness, for when the
+// history is about to be written back.
+func readHistory(store Storage, name string) (SelectionHistory, error) {
+	data, err := store.Get(name)
+	if os.IsNotExist(err) {
+		return SelectionHistory{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return decodeHistory(data)
+}
+
+func decodeHistory(data []byte) (SelectionHistory, error) {
+	if data == nil {
+		return SelectionHistory{}, nil
+	}
+	return ReadHistory(bytes.NewReader(data))
+}
+
+// SaveHistory replaces the whole history.
+func SaveHistory(store Storage, name string, history SelectionHistory) error {
+	buf := bytes.Buffer{}
+	if err := WriteHistory(&buf, history); err != nil {
+		return err
+	}
+	return store.Put(name, buf.Bytes())
+}
+
+// AppendHistory adds a pick to the end of the history, unless it's
+// already the last one there, as when another replica recorded it
+// first.  The storage's Update keeps replicas appending at once from
+// losing each other's picks.  An unreadable history is an error here
+// rather than an empty one, so it isn't overwritten with just the one
+// pick.
+func AppendHistory(store Storage, name string, pick DailySelection) error {
+	return store.Update(name, func(data []byte) ([]byte, error) {
+		history, err := decodeHistory(data)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(history); n > 0 && history[n-1].Time.Equal(pick.Time) {
+			return data, nil
+		}
+
+		buf := bytes.Buffer{}
+		err = WriteHistory(&buf, append(history, pick))
+		return buf.Bytes(), err
+	})
+}
+
+// PickedSince returns the keys of every dataset picked at or after t.
+func (h SelectionHistory) PickedSince(t time.Time) map[string]bool {
+	out := map[string]bool{}
+	for _, v := range h {
+		if !v.Time.Before(t) {
+			out[v.Dataset.Key()] = true
+		}
+	}
+	return out
+}
+
+// Before returns the picks made before t.
+func (h SelectionHistory) Before(t time.Time) SelectionHistory {
+	out := SelectionHistory{}
+	for _, v := range h {
+		if v.Time.Before(t) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Eligible filters candidates down to those not picked within the
+// no-repeat window ending at t.  If that leaves nothing, the window
+// is halved until something qualifies, so a small pool degrades to
+// "least recently shown" instead of failing outright.
+func (h SelectionHistory) Eligible(
+	candidates []DailySelection,
+	t time.Time,
+	windowDays int,
+) []DailySelection {
+	for days := windowDays; ; days /= 2 {
+		recent := h.PickedSince(t.AddDate(0, 0, -days))
+		out := make([]DailySelection, 0, len(candidates))
+		for _, v := range candidates {
+			if !recent[v.Dataset.Key()] {
+				out = append(out, v)
+			}
+		}
+
+		if len(out) > 0 || days <= 0 {
+			if days != windowDays {
+				log.Printf(
+					"No-repeat window of %d days exhausted, shrunk to %d",
+					windowDays,
+					days,
+				)
+			}
+			return out
+		}
+	}
+}
+
+// On returns the last pick made on a date (YYYY-MM-DD) in the given
+// zone.
+func (h SelectionHistory) On(
+	date string,
+	location *time.Location,
+) (DailySelection, bool) {
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].Time.In(location).Format(timeFormat) == date {
+			return h[i], true
+		}
+	}
+	return DailySelection{}, false
+}
+
+// Recent returns the last pick made on each of the given number of
+// days up to and including t's date, oldest first.
+func (h SelectionHistory) Recent(
+	days int,
+	t time.Time,
+	location *time.Location,
+) []DailySelection {
+	t = t.In(location)
+	start := time.Date(
+		t.Year(), t.Month(), t.Day()-days+1,
+		0, 0, 0, 0,
+		location,
+	)
+	end := start.AddDate(0, 0, days)
+
+	out := []DailySelection{}
+	seen := map[string]bool{}
+	for i := len(h) - 1; i >= 0; i-- {
+		v := h[i]
+		if v.Time.Before(start) || !v.Time.Before(end) {
+			continue
+		}
+		date := v.Time.In(location).Format(timeFormat)
+		if !seen[date] {
+			seen[date] = true
+			out = append([]DailySelection{v}, out...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAppendHistoryConcurrent(t *testing.T) {
+	store := LocalStorage{Dir: t.TempDir()}
+	if err := AppendHistory(store, "history", testSelection(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	wait := sync.WaitGroup{}
+	for i := 1; i <= 20; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			if err := AppendHistory(store, "history", testSelection(i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	// Readers racing the writers must never see a partial file
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := readHistory(store, "history"); err != nil {
+					t.Error("Read a broken history:", err)
+					return
+				}
+			}
+		}()
+	}
+	wait.Wait()
+
+	if history := LoadHistory(store, "history"); len(history) != 21 {
+		t.Errorf("Expected 21 picks, got %d", len(history))
+	}
+}
+
+func TestAppendHistoryCorrupt(t *testing.T) {
+	store := LocalStorage{Dir: t.TempDir()}
+	if err := store.Put("history", []byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AppendHistory(store, "history", testSelection(0)); err == nil {
+		t.Error("Expected an error appending to a corrupt history")
+	}
+	if data, _ := store.Get("history"); string(data) != "garbage" {
+		t.Error("Corrupt history was overwritten")
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultLocale = "en"
+
+// Name of the cookie that remembers a ?lang= choice
+const localeCookie = "lang"
+
+// Locale formats dates and numbers and translates the site copy for
+// one language.
+type Locale struct {
+	Tag  string
+	Name string
+
+	// Arguments are day, month name and year
+	dateFormat string
+	months     [12]string
+
+	decimal, group string
+	percent        string
+
+	messages map[string]string
+}
+
+// Locales holds every supported locale by tag.
+var Locales map[string]*Locale = map[string]*Locale{
+	"en": {
+		Tag:        "en",
+		Name:       "English",
+		dateFormat: "%[2]s %[1]d, %[3]d",
+		months: [12]string{
+			"January", "February", "March", "April", "May", "June",
+			"July", "August", "September", "October", "November",
+			"December",
+		},
+		decimal:  ".",
+		group:    ",",
+		percent:  "%",
+		messages: messagesEN,
+	},
+	"de": {
+		Tag:        "de",
+		Name:       "Deutsch",
+		dateFormat: "%[1]d. %[2]s %[3]d",
+		months: [12]string{
+			"Januar", "Februar", "März", "April", "Mai", "Juni",
+			"Juli", "August", "September", "Oktober", "November",
+			"Dezember",
+		},
+		decimal:  ",",
+		group:    ".",
+		percent:  "\u00a0%",
+		messages: messagesDE,
+	},
+	"fr": {
+		Tag:        "fr",
+		Name:       "Français",
+		dateFormat: "%[1]d %[2]s %[3]d",
+		months: [12]string{
+			"janvier", "février", "mars", "avril", "mai", "juin",
+			"juillet", "août", "septembre", "octobre", "novembre",
+			"décembre",
+		},
+		decimal:  ",",
+		group:    "\u202f",
+		percent:  "\u00a0%",
+		messages: messagesFR,
+	},
+	"es": {
+		Tag:        "es",
+		Name:       "Español",
+		dateFormat: "%[1]d de %[2]s de %[3]d",
+		months: [12]string{
+			"enero", "febrero", "marzo", "abril", "mayo", "junio",
+			"julio", "agosto", "septiembre", "octubre", "noviembre",
+			"diciembre",
+		},
+		decimal:  ",",
+		group:    ".",
+		percent:  "\u00a0%",
+		messages: messagesES,
+	},
+}
+
+// LocaleTags lists the supported locales in a stable order.
+func LocaleTags() []string {
+	out := make([]string, 0, len(Locales))
+	for k := range Locales {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NegotiateLocale picks a locale for a request.  An explicit ?lang=
+// wins and is remembered in a cookie, then the cookie, then the best
+// match from Accept-Language.
+func NegotiateLocale(w http.ResponseWriter, r *http.Request) *Locale {
+	l := negotiateLocale(w, r)
+	w.Header().Set("Content-Language", l.Tag)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Add("Vary", "Cookie")
+	return l
+}
+
+func negotiateLocale(w http.ResponseWriter, r *http.Request) *Locale {
+	if tag := strings.ToLower(r.URL.Query().Get("lang")); tag != "" {
+		if l, ok := Locales[tag]; ok {
+			http.SetCookie(w, &http.Cookie{
+				Name:   localeCookie,
+				Value:  l.Tag,
+				Path:   "/",
+				MaxAge: 365 * 24 * 60 * 60,
+			})
+			return l
+		}
+	}
+
+	if cookie, err := r.Cookie(localeCookie); err == nil {
+		if l, ok := Locales[cookie.Value]; ok {
+			return l
+		}
+	}
+
+	return MatchLocale(r.Header.Get("Accept-Language"))
+}
+
+// MatchLocale returns the supported locale an Accept-Language header
+// most prefers, matching on the primary language only (so "de-AT"
+// gets German).
+func MatchLocale(header string) *Locale {
+	best, bestQ := Locales[defaultLocale], 0.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.IndexAny(tag, "-_"); i >= 0 {
+			tag = tag[:i]
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				parsed, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+
+		if l, ok := Locales[tag]; ok && q > bestQ {
+			best, bestQ = l, q
+		}
+	}
+	return best
+}
+
+// T translates a message, filling in its arguments.  Messages are
+// trusted HTML, but arguments are escaped unless they're already
+// template.HTML.
+func (l *Locale) T(key string, args ...interface{}) template.HTML {
+	message, ok := l.messages[key]
+	if !ok {
+		message, ok = messagesEN[key]
+	}
+	if !ok {
+		message = template.HTMLEscapeString(key)
+	}
+
+	escaped := make([]interface{}, len(args))
+	for i, v := range args {
+		if h, ok := v.(template.HTML); ok {
+			escaped[i] = string(h)
+		} else {
+			escaped[i] = template.HTMLEscapeString(fmt.Sprint(v))
+		}
+	}
+	return template.HTML(fmt.Sprintf(message, escaped...))
+}
+
+// Text is T for plain text contexts, like attribute values or headers.
+func (l *Locale) Text(key string, args ...interface{}) string {
+	message, ok := l.messages[key]
+	if !ok {
+		message, ok = messagesEN[key]
+	}
+	if !ok {
+		message = key
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+func (l *Locale) Date(t time.Time) string {
+	return fmt.Sprintf(l.dateFormat, t.Day(), l.months[t.Month()-1], t.Year())
+}
+
+func (l *Locale) DateTime(t time.Time) string {
+	return l.Date(t) + " " + t.Format("15:04 MST")
+}
+
+// Number formats f with the given number of decimal places, using the
+// locale's digit grouping and decimal separator.
+func (l *Locale) Number(f float64, decimals int) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	raw := strconv.FormatFloat(math.Abs(f), 'f', decimals, 64)
+	whole, fraction := raw, ""
+	if i := strings.IndexByte(raw, '.'); i >= 0 {
+		whole, fraction = raw[:i], raw[i+1:]
+	}
+
+	out := &strings.Builder{}
+	if f < 0 && strings.Trim(raw, "0.") != "" {
+		out.WriteString("-")
+	}
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			out.WriteString(l.group)
+		}
+		out.WriteRune(c)
+	}
+	if fraction != "" {
+		out.WriteString(l.decimal)
+		out.WriteString(fraction)
+	}
+	return out.String()
+}
+
+func (l *Locale) Percent(f float64, decimals int) string {
+	return l.Number(f, decimals) + l.percent
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lease is held by at most one replica at a time, which is the one
+// that runs the crawl.
+type Lease interface {
+	// Acquire takes the lease, or renews it if it's already held,
+	// and reports whether this replica holds it.
+	Acquire() (bool, error)
+	Release() error
+}
+
+// NewLease sets up the lease backend named by kind: "file" locks
+// target on shared storage, "sql" uses a row in the database at the
+// URL target.
+func NewLease(kind, target string, ttl time.Duration) (Lease, error) {
+	switch kind {
+	case "file":
+		return NewFileLease(target), nil
+	case "sql":
+		return NewSQLLease(target, "crawl", LeaseHolder(), ttl)
+	}
+	return nil, fmt.Errorf("Unknown lease backend %q", kind)
+}
+
+// LeaseHolder identifies this replica in lease records and logs.
+func LeaseHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Coordinator decides which replica crawls.  The leader runs the
+// selection and writes it to shared storage, the others wait for it
+// to show up there.
+type Coordinator struct {
+	Lease Lease
+
+	// Renew is how often the leader renews the lease while crawling,
+	// Poll how often followers check for the new selection
+	Renew time.Duration
+	Poll  time.Duration
+}
+
+// Run calls lead if this replica gets the lease, and follow otherwise.
+// Without a coordinator every replica leads.
+func (c *Coordinator) Run(lead func(), follow func()) {
+	if c == nil {
+		lead()
+		return
+	}
+
+	held, err := c.Lease.Acquire()
+	if err != nil {
+		log.Println("Error acquiring crawl lease, following:", err)
+	}
+	if !held {
+		log.Println("Another replica holds the crawl lease, following")
+		follow()
+		return
+	}
+
+	log.Println("Acquired crawl lease")
+	done := make(chan struct{})
+	wait := sync.WaitGroup{}
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		ticker := time.NewTicker(c.Renew)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if held, err := c.Lease.Acquire(); err != nil {
+					log.Println("Error renewing crawl lease:", err)
+				} else if !held {
+					log.Println("Lost the crawl lease to another replica")
+				}
+			}
+		}
+	}()
+
+	defer func() {
+		close(done)
+		wait.Wait()
+		if err := c.Lease.Release(); err != nil {
+			log.Println("Error releasing crawl lease:", err)
+		}
+	}()
+	lead()
+}
+
+// FollowSelection waits for the leader to store a selection newer
+// than the current one, then loads it as loadNewerSelection does.  It
+// gives up at deadline, keeping the old selection.
+func FollowSelection(
+	config Config,
+	poll time.Duration,
+	deadline time.Time,
+	selection *SelectionStore,
+	candidates *CandidateList,
+) {
+	since := selection.Load().Time
+
+	for {
+		if latest, ok := loadNewerSelection(config, since, selection, candidates); ok {
+			log.Println("Loaded selection from leader, made", latest.Time)
+			return
+		}
+
+		if !config.Clock.Now().Add(poll).Before(deadline) {
+			log.Println("Gave up waiting for the leader's selection")
+			return
+		}
+		config.Clock.Sleep(poll)
+	}
+}
+
+// WatchSelection checks shared storage every poll until stop is
+// closed, loading any selection newer than the current one.  That
+// picks up pins and refreshes made through another replica, which
+// don't wait for the schedule.
+func WatchSelection(
+	config Config,
+	poll time.Duration,
+	selection *SelectionStore,
+	candidates *CandidateList,
+	stop <-chan struct{},
+) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		// A selection running here stores its own pick when it's done
+		if !SelectionInProgress() {
+			since := selection.Load().Time
+			latest, ok := loadNewerSelection(config, since, selection, candidates)
+			if ok {
+				log.Println("Loaded selection from another replica, made", latest.Time)
+			}
+		}
+		config.Clock.Sleep(poll)
+	}
+}
+
+// loadNewerSelection makes the selection in shared storage current if
+// it was made after since, along with its candidates.  The pick is
+// appended to the shared history in case the replica that made it
+// stopped before recording it, which is a no-op otherwise.
+func loadNewerSelection(
+	config Config,
+	since time.Time,
+	selection *SelectionStore,
+	candidates *CandidateList,
+) (DailySelection, bool) {
+	// Errors, including a missing cache, just mean trying again later
+	latest, err := LoadBackup(config.Cache, config.TempFile)
+	if err != nil || !latest.Time.After(since) {
+		return DailySelection{}, false
+	}
+
+	selection.Store(latest)
+	followCandidates(config, candidates)
+
+	// The series is already stored
+	entry := latest
+	entry.Series = nil
+	if err := AppendHistory(config.Cache, config.HistoryFile, entry); err != nil {
+		log.Println("Error writing selection history:", err)
+	}
+	return latest, true
+}
+
+// followCandidates copies the leader's candidate list from shared
+// storage, falling back to the archive in case it's shared instead.
+func followCandidates(config Config, candidates *CandidateList) {
+	t, list, err := LoadCandidates(config.Cache, config.TempFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error reading cached candidate list:", err)
+		}
+		candidates.Reload()
+		return
+	}
+	if err := candidates.Store(config, t, list); err != nil {
+		log.Println("Error writing candidate list:", err)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build unix
+
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+// FileLease is an exclusive flock on a file on shared storage.  The
+// lock goes away with the process, so a crashed leader can't hold the
+// lease forever, but the filesystem has to support flock across
+// hosts (NFSv4 and most network filesystems do).
+type FileLease struct {
+	lock sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileLease(path string) *FileLease {
+	return &FileLease{path: path}
+}
+
+func (l *FileLease) Acquire() (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return false, nil
+	} else if err != nil {
+		file.Close()
+		return false, err
+	}
+
+	// Recording the holder is only for whoever's debugging
+	file.Truncate(0)
+	file.WriteAt([]byte(LeaseHolder()+"\n"), 0)
+
+	l.file = file
+	return true, nil
+}
+
+func (l *FileLease) Release() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+	l.file = nil
+	return err
+}
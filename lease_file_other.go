@@ -0,0 +1,41 @@
+//go:build !unix
+
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+)
+
+// FileLease needs flock, so on other platforms it can never be held.
+type FileLease struct{}
+
+func NewFileLease(path string) *FileLease {
+	return &FileLease{}
+}
+
+func (l *FileLease) Acquire() (bool, error) {
+	return false, errors.New("File leases aren't supported on this platform")
+}
+
+func (l *FileLease) Release() error {
+	return nil
+}
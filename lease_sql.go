@@ -0,0 +1,94 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"database/sql"
+	_ "github.com/lib/pq"
+	"time"
+)
+
+// SQLLease is a row in a PostgreSQL table, held until it expires or
+// is released.  Expiry uses the database's clock, so replicas with
+// skewed clocks still agree on it.
+type SQLLease struct {
+	db     *sql.DB
+	name   string
+	holder string
+	ttl    time.Duration
+}
+
+func NewSQLLease(
+	url string,
+	name string,
+	holder string,
+	ttl time.Duration,
+) (*SQLLease, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS leases (
+			name TEXT PRIMARY KEY,
+			holder TEXT NOT NULL,
+			expires TIMESTAMPTZ NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLLease{db, name, holder, ttl}, nil
+}
+
+// Acquire takes the row if it's missing, expired or already ours,
+// all in one statement so two replicas can't both win.
+func (l *SQLLease) Acquire() (bool, error) {
+	result, err := l.db.Exec(
+		`
+		INSERT INTO leases (name, holder, expires)
+		VALUES ($1, $2, now() + $3 * interval '1 second')
+		ON CONFLICT (name) DO UPDATE
+		SET holder = EXCLUDED.holder, expires = EXCLUDED.expires
+		WHERE leases.holder = EXCLUDED.holder OR leases.expires < now()
+		`,
+		l.name,
+		l.holder,
+		l.ttl.Seconds(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (l *SQLLease) Release() error {
+	_, err := l.db.Exec(
+		"DELETE FROM leases WHERE name = $1 AND holder = $2",
+		l.name,
+		l.holder,
+	)
+	return err
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollowerSharesState(t *testing.T) {
+	clock := newFakeClock(time.Date(2017, 6, 2, 2, 0, 0, 0, time.UTC))
+	leader := selectorTest(t, clock)
+
+	// The follower shares only the cache with the leader
+	follower := leader
+	followerDir := t.TempDir()
+
+	replica := func(dir string) (*AdminState, *CandidateList) {
+		admin := LoadAdminState(filepath.Join(dir, "admin"))
+		admin.Share(leader.Cache, leader.TempFile+"-admin")
+		return admin, LoadCandidateList(filepath.Join(dir, "candidates"))
+	}
+	leaderAdmin, leaderCandidates := replica(leader.Cache.(LocalStorage).Dir)
+	followerAdmin, followerCandidates := replica(followerDir)
+
+	// A pin posted to the follower has to reach the leader, which
+	// would otherwise pick E
+	if err := followerAdmin.Pin("2017-06-02", Datasets[0]); err != nil {
+		t.Fatal(err)
+	}
+	SelectSynchronously(leader, NewSelectionStore(), leaderAdmin, leaderCandidates)
+
+	selection := NewSelectionStore()
+	FollowSelection(
+		follower,
+		time.Second,
+		clock.Now().Add(time.Minute),
+		selection,
+		followerCandidates,
+	)
+	if current := selection.Load(); current.Dataset.Dataset != "A" {
+		t.Fatalf("Expected the pinned A, got %s", current.Dataset.Dataset)
+	}
+
+	// The history is shared too, so the follower mustn't record the
+	// pick a second time
+	history := LoadHistory(follower.Cache, follower.HistoryFile)
+	if len(history) != 1 || history[0].Dataset.Dataset != "A" {
+		t.Fatalf("Shared history is %v", history)
+	}
+	if len(history[0].Series) != 0 {
+		t.Error("Shared history has the series inline")
+	}
+
+	leaderTime, _ := leaderCandidates.Get()
+	followerTime, list := followerCandidates.Get()
+	if !followerTime.Equal(leaderTime) || len(list) != len(Datasets) {
+		t.Errorf(
+			"Follower has %d candidates from %s, expected %d from %s",
+			len(list),
+			followerTime,
+			len(Datasets),
+			leaderTime,
+		)
+	}
+	if _, _, err := followerCandidates.Archived("2017-06-02"); err != nil {
+		t.Errorf("Follower didn't archive the candidates: %s", err)
+	}
+}
+
+func TestWatchSelection(t *testing.T) {
+	clock := newFakeClock(time.Date(2017, 6, 2, 2, 0, 0, 0, time.UTC))
+	leader := selectorTest(t, clock)
+	leaderAdmin := LoadAdminState(filepath.Join(t.TempDir(), "admin"))
+	leaderCandidates := LoadCandidateList(filepath.Join(t.TempDir(), "candidates"))
+	SelectSynchronously(leader, NewSelectionStore(), leaderAdmin, leaderCandidates)
+
+	follower := leader
+	follower.Clock = SystemClock
+	first, err := LoadBackup(leader.Cache, leader.TempFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selection := NewSelectionStore()
+	selection.Store(first)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		WatchSelection(
+			follower,
+			10*time.Millisecond,
+			selection,
+			LoadCandidateList(filepath.Join(t.TempDir(), "candidates")),
+			stop,
+		)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// A later refresh on the leader, outside the schedule
+	clock.Sleep(time.Hour)
+	SelectSynchronously(leader, NewSelectionStore(), leaderAdmin, leaderCandidates)
+	expected, err := LoadBackup(leader.Cache, leader.TempFile)
+	if err != nil || !expected.Time.After(first.Time) {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !selection.Load().Time.Equal(expected.Time) {
+		if time.Now().After(deadline) {
+			t.Fatal("The follower never loaded the newer selection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -23,7 +23,9 @@ import (
 	"time"
 )
 
-func RunLimited(f func(d Dataset)) {
+// RunLimited calls f for every dataset, waiting as needed to stay
+// within Limits.
+func RunLimited(clock Clock, f func(d Dataset)) {
 	log := make([]time.Time, 0, len(Datasets))
 
 	canContinue := func() bool {
@@ -32,7 +34,7 @@ func RunLimited(f func(d Dataset)) {
 			countPerLimit[k] = 0
 		}
 
-		t := time.Now()
+		t := clock.Now()
 		for _, v := range log {
 			delta := t.Sub(v)
 			for k := range countPerLimit {
@@ -53,10 +55,10 @@ func RunLimited(f func(d Dataset)) {
 
 	for _, v := range Datasets {
 		for !canContinue() {
-			time.Sleep(time.Second)
+			clock.Sleep(time.Second)
 		}
 
 		f(v)
-		log = append(log, time.Now())
+		log = append(log, clock.Now())
 	}
 }
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunLimited(t *testing.T) {
+	oldDatasets, oldLimits := Datasets, Limits
+	defer func() {
+		Datasets, Limits = oldDatasets, oldLimits
+	}()
+
+	Datasets = []Dataset{
+		{"WIKI", "A", ""},
+		{"WIKI", "B", ""},
+		{"WIKI", "C", ""},
+		{"WIKI", "D", ""},
+		{"WIKI", "E", ""},
+	}
+	Limits = map[time.Duration]int{
+		10 * time.Second: 2,
+		time.Minute:      4,
+	}
+
+	start := time.Date(2017, 6, 1, 2, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	called := []time.Duration{}
+	seen := []string{}
+	RunLimited(clock, func(d Dataset) {
+		called = append(called, clock.Now().Sub(start))
+		seen = append(seen, d.Dataset)
+	})
+
+	// Two at once, two more once the first pair is more than ten
+	// seconds old, then the last once the first is over a minute old
+	expected := []time.Duration{
+		0,
+		0,
+		11 * time.Second,
+		11 * time.Second,
+		61 * time.Second,
+	}
+	if len(called) != len(expected) {
+		t.Fatalf("Expected %d calls, got %d", len(expected), len(called))
+	}
+	for i := range expected {
+		if called[i] != expected[i] {
+			t.Errorf("Call %d at %s, expected %s", i, called[i], expected[i])
+		}
+		if seen[i] != Datasets[i].Dataset {
+			t.Errorf("Call %d for %s, expected %s", i, seen[i], Datasets[i].Dataset)
+		}
+	}
+}
+
+func TestRunLimitedUnderLimit(t *testing.T) {
+	oldDatasets, oldLimits := Datasets, Limits
+	defer func() {
+		Datasets, Limits = oldDatasets, oldLimits
+	}()
+
+	Datasets = []Dataset{
+		{"WIKI", "A", ""},
+		{"WIKI", "B", ""},
+		{"WIKI", "C", ""},
+	}
+	Limits = map[time.Duration]int{time.Second: 3}
+
+	start := time.Date(2017, 6, 1, 2, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	calls := 0
+	RunLimited(clock, func(d Dataset) {
+		calls++
+	})
+
+	if calls != len(Datasets) {
+		t.Errorf("Expected %d calls, got %d", len(Datasets), calls)
+	}
+	if waited := clock.Now().Sub(start); waited != 0 {
+		t.Errorf("Waited %s with no limit reached", waited)
+	}
+}
@@ -20,28 +20,168 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme"
 	"log"
-	"math/rand"
 	"net/http"
 	"os"
-	"sync"
+	"strings"
 	"time"
+
+	// The image has no zoneinfo of its own, so refresh_timezone needs
+	// the copy built into the binary
+	_ "time/tzdata"
 )
 
 type Config struct {
-	APIKey   string
-	TempFile string
+	APIKey        string
+	TempFile      string
+	Cache         Storage
+	HistoryFile   string
+	NoRepeatDays  int
+	Policy        SelectionPolicy
+	Schedule      *Schedule
+	AdminToken    string
+	Calculator    CalculatorConfig
+	Site          SiteConfig
+	Templates     *Templates
+	Notifications *Notifications
+	Clock         Clock
+}
+
+// CalculatorConfig holds the calculator's defaults.
+type CalculatorConfig struct {
+	Amount     float64
+	FeePercent float64
+	FeeFlat    float64
 }
 
 func main() {
+	replay := flag.String(
+		"replay",
+		"",
+		"Print what the pick would have been on a date (YYYY-MM-DD) and exit",
+	)
+	flag.Parse()
+
 	viper.SetDefault("port", 80)
 	viper.SetDefault("temp_file", "cache")
+	viper.SetDefault("history_file", "history")
+	viper.SetDefault("no_repeat_days", 30)
+	viper.SetDefault("selection_policy", "uniform")
+	viper.SetDefault("select_top_n", 20)
+	viper.SetDefault("softmax_temperature", 0.1)
+	viper.SetDefault("refresh_schedule", "0 2 * * *")
+	viper.SetDefault("refresh_timezone", "Local")
+	viper.SetDefault("admin_file", "admin")
+	viper.SetDefault("candidates_dir", "candidates")
+	viper.SetDefault("calculator_amount", 1000)
+	viper.SetDefault("calculator_fee_percent", 0)
+	viper.SetDefault("calculator_fee_flat", 0)
+	viper.SetDefault("site_title", "Daily Hindsight")
+	viper.SetDefault("contact_email", "baddata@biebersprojects.com")
+	viper.SetDefault(
+		"source_url",
+		"https://www.github.com/bieber/dailyhindsight/",
+	)
+	viper.SetDefault("audit_log", "audit.log")
+	viper.SetDefault("notify_retries", 3)
+	viper.SetDefault("notify_backoff", "30s")
+	viper.SetDefault("dead_letter_log", "notifications.failed")
+	viper.SetDefault("smtp_port", 587)
+	viper.SetDefault("email_digest", "daily")
+	viper.SetDefault("email_weekday", "Sunday")
+	viper.SetDefault("email_locale", "en")
+	viper.SetDefault("cache_backend", "local")
+	viper.SetDefault("s3_region", "us-east-1")
+	viper.SetDefault("lease_file", "crawl.lock")
+	viper.SetDefault("https_port", 443)
+	viper.SetDefault("acme_directory", acme.LetsEncryptURL)
+	viper.SetDefault("acme_cache_dir", "acme")
+	viper.SetDefault("tls_min_version", "1.2")
+	viper.SetDefault("hsts_max_age", "8760h")
+	viper.SetDefault("read_header_timeout", "5s")
+	viper.SetDefault("read_timeout", "30s")
+	viper.SetDefault("write_timeout", "60s")
+	viper.SetDefault("idle_timeout", "120s")
+	viper.SetDefault("max_header_bytes", 64<<10)
+	viper.SetDefault("proxy_header", "xff")
+	viper.SetDefault("lease_ttl", "10m")
+	viper.SetDefault("lease_poll", "1m")
 
 	viper.BindEnv("port")
 	viper.BindEnv("api_key")
 	viper.BindEnv("temp_file")
+	viper.BindEnv("history_file")
+	viper.BindEnv("no_repeat_days")
+	viper.BindEnv("selection_policy")
+	viper.BindEnv("select_top_n")
+	viper.BindEnv("softmax_temperature")
+	viper.BindEnv("refresh_schedule")
+	viper.BindEnv("refresh_timezone")
+	viper.BindEnv("admin_token")
+	viper.BindEnv("admin_file")
+	viper.BindEnv("candidates_dir")
+	viper.BindEnv("calculator_amount")
+	viper.BindEnv("calculator_fee_percent")
+	viper.BindEnv("calculator_fee_flat")
+	viper.BindEnv("site_title")
+	viper.BindEnv("contact_email")
+	viper.BindEnv("source_url")
+	viper.BindEnv("site_url")
+	viper.BindEnv("template_dir")
+	viper.BindEnv("static_dir")
+	viper.BindEnv("audit_log")
+	viper.BindEnv("notify_retries")
+	viper.BindEnv("notify_backoff")
+	viper.BindEnv("dead_letter_log")
+	viper.BindEnv("smtp_host")
+	viper.BindEnv("smtp_port")
+	viper.BindEnv("smtp_username")
+	viper.BindEnv("smtp_password")
+	viper.BindEnv("email_from")
+	viper.BindEnv("email_to")
+	viper.BindEnv("email_digest")
+	viper.BindEnv("email_weekday")
+	viper.BindEnv("email_locale")
+	viper.BindEnv("cache_backend")
+	viper.BindEnv("cache_database_url")
+	viper.BindEnv("s3_endpoint")
+	viper.BindEnv("s3_region")
+	viper.BindEnv("s3_bucket")
+	viper.BindEnv("s3_prefix")
+	viper.BindEnv("s3_access_key")
+	viper.BindEnv("s3_secret_key")
+	viper.BindEnv("s3_path_style")
+	viper.BindEnv("https_port")
+	viper.BindEnv("tls_cert_file")
+	viper.BindEnv("tls_key_file")
+	viper.BindEnv("acme_domains")
+	viper.BindEnv("acme_directory")
+	viper.BindEnv("acme_email")
+	viper.BindEnv("acme_cache_dir")
+	viper.BindEnv("acme_ca_file")
+	viper.BindEnv("tls_min_version")
+	viper.BindEnv("hsts_max_age")
+	viper.BindEnv("hsts_include_subdomains")
+	viper.BindEnv("read_header_timeout")
+	viper.BindEnv("read_timeout")
+	viper.BindEnv("write_timeout")
+	viper.BindEnv("idle_timeout")
+	viper.BindEnv("max_header_bytes")
+	viper.BindEnv("content_security_policy")
+	viper.BindEnv("referrer_policy")
+	viper.BindEnv("frame_options")
+	viper.BindEnv("trusted_proxies")
+	viper.BindEnv("proxy_header")
+	viper.BindEnv("rate_limit_allowlist")
+	viper.BindEnv("lease_backend")
+	viper.BindEnv("lease_file")
+	viper.BindEnv("lease_database_url")
+	viper.BindEnv("lease_ttl")
+	viper.BindEnv("lease_poll")
 
 	viper.SetConfigName("config")
 	viper.AddConfigPath(".")
@@ -53,53 +193,412 @@ func main() {
 		log.Printf("Couldn't load config file: %s", err.Error())
 	}
 
-	config := Config{
-		APIKey:   viper.GetString("api_key"),
-		TempFile: viper.GetString("temp_file"),
+	policy, err := NewSelectionPolicy(
+		viper.GetString("selection_policy"),
+		viper.GetInt("select_top_n"),
+		viper.GetFloat64("softmax_temperature"),
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	rand.Seed(time.Now().Unix())
+	location, err := time.LoadLocation(viper.GetString("refresh_timezone"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	schedule, err := ParseSchedule(
+		viper.GetString("refresh_schedule"),
+		location,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clock := SystemClock
+	if schedule.Next(clock.Now()).IsZero() {
+		log.Fatalf("Refresh schedule %q never runs", schedule.Spec)
+	}
 
-	selection, selectionLock := DailySelection{}, sync.RWMutex{}
+	site := SiteConfig{
+		Title:        viper.GetString("site_title"),
+		ContactEmail: viper.GetString("contact_email"),
+		SourceURL:    viper.GetString("source_url"),
+		URL:          viper.GetString("site_url"),
+	}
+	if site.URL == "" {
+		log.Println("No site_url set, so the front page has no share links")
+	}
+	templates, err := LoadTemplates(viper.GetString("template_dir"), site)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if cacheFin, err := os.Open(config.TempFile); err != nil {
-		log.Println("No cache file found, loading synchronously")
-		SelectSynchronously(config, &selection, &selectionLock)
-	} else {
-		selection, err = ReadBackup(cacheFin)
-		cacheFin.Close()
+	var cache Storage
+	switch backend := viper.GetString("cache_backend"); backend {
+	case "local":
+		cache = LocalStorage{}
+	case "s3":
+		cache, err = NewS3Storage(
+			viper.GetString("s3_endpoint"),
+			viper.GetString("s3_region"),
+			viper.GetString("s3_bucket"),
+			viper.GetString("s3_prefix"),
+			viper.GetString("s3_access_key"),
+			viper.GetString("s3_secret_key"),
+			viper.GetBool("s3_path_style"),
+		)
+	case "sql":
+		cache, err = NewSQLStorage(viper.GetString("cache_database_url"))
+	default:
+		log.Fatalf("Unknown cache backend %q", backend)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config := Config{
+		APIKey:       viper.GetString("api_key"),
+		TempFile:     viper.GetString("temp_file"),
+		Cache:        cache,
+		HistoryFile:  viper.GetString("history_file"),
+		NoRepeatDays: viper.GetInt("no_repeat_days"),
+		Policy:       policy,
+		Schedule:     schedule,
+		AdminToken:   viper.GetString("admin_token"),
+		Calculator: CalculatorConfig{
+			Amount:     viper.GetFloat64("calculator_amount"),
+			FeePercent: viper.GetFloat64("calculator_fee_percent"),
+			FeeFlat:    viper.GetFloat64("calculator_fee_flat"),
+		},
+		Site:      site,
+		Templates: templates,
+		Clock:     clock,
+	}
+
+	// The history used to be a local file whatever the cache backend,
+	// so bring it along the first time a shared cache is used
+	if _, local := cache.(LocalStorage); !local {
+		_, err := cache.Get(config.HistoryFile)
+		if os.IsNotExist(err) {
+			if data, err := (LocalStorage{}).Get(config.HistoryFile); err == nil {
+				log.Println("Copying the history file into the cache")
+				if err := cache.Put(config.HistoryFile, data); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+	}
+
+	if viper.IsSet("trusted_proxies") {
+		TrustedProxies, err = ParseCIDRs(
+			viper.GetStringSlice("trusted_proxies")...,
+		)
 		if err != nil {
-			log.Println("Error reading cache file, loading synchronously")
-			SelectSynchronously(config, &selection, &selectionLock)
-		} else if time.Now().After(NextLoadTime(selection.Time)) {
-			log.Println("Cache file is too old, loading synchronously")
-			SelectSynchronously(config, &selection, &selectionLock)
-		} else {
-			log.Println("Loaded cache from", selection.Time)
+			log.Fatal(err)
 		}
 	}
+	ProxyHeader = viper.GetString("proxy_header")
+	if !ValidProxyHeader(ProxyHeader) {
+		log.Fatalf("Unknown proxy_header %q", ProxyHeader)
+	}
 
-	time.AfterFunc(
-		NextLoadTime(selection.Time).Sub(time.Now()),
-		func() {
-			ticker := time.NewTicker(time.Hour * 24)
-			go func() {
-				for {
-					<-ticker.C
-					SelectSynchronously(config, &selection, &selectionLock)
-				}
-			}()
-			SelectSynchronously(config, &selection, &selectionLock)
-		},
+	// Groups and fields not in rate_limits keep their defaults
+	rateLimits := map[string]RateLimitOverride{}
+	if err := viper.UnmarshalKey("rate_limits", &rateLimits); err != nil {
+		log.Fatal(err)
+	}
+	if err := MergeRateLimits(rateLimits); err != nil {
+		log.Fatal(err)
+	}
+	RateLimitClock = clock
+	RateLimitAllowlist, err = ParseCIDRs(
+		viper.GetStringSlice("rate_limit_allowlist")...,
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Unset headers keep their defaults, set but empty ones are left
+	// off entirely
+	for key, header := range map[string]string{
+		"content_security_policy": "Content-Security-Policy",
+		"referrer_policy":         "Referrer-Policy",
+		"frame_options":           "X-Frame-Options",
+	} {
+		if viper.IsSet(key) {
+			SecurityHeaders[header] = viper.GetString(key)
+		}
+	}
+
+	// Config keys come back lowercased, so calendar and database names
+	// are normalized to upper case to match the built-ins
+	for name, path := range viper.GetStringMapString("calendars") {
+		if err := LoadCalendar(strings.ToUpper(name), path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for database, name := range viper.GetStringMapString("database_calendars") {
+		name = strings.ToUpper(name)
+		if _, ok := Calendars[name]; !ok {
+			log.Fatalf("Unknown calendar %s for database %s", name, database)
+		}
+		DataCalendars[strings.ToUpper(database)] = name
+	}
+
+	notifications := &Notifications{
+		Retries: viper.GetInt("notify_retries"),
+		Backoff: viper.GetDuration("notify_backoff"),
+		Clock:   clock,
+	}
+	webhooks := []WebhookConfig{}
+	if err := viper.UnmarshalKey("webhooks", &webhooks); err != nil {
+		log.Fatal(err)
+	}
+	for _, v := range webhooks {
+		webhook, err := NewWebhook(config, v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		notifications.Notifiers = append(notifications.Notifiers, webhook)
+	}
+	if viper.GetString("smtp_host") != "" {
+		emails, err := NewEmailNotifiers(
+			config,
+			EmailConfig{
+				Host:     viper.GetString("smtp_host"),
+				Port:     viper.GetInt("smtp_port"),
+				Username: viper.GetString("smtp_username"),
+				Password: viper.GetString("smtp_password"),
+				From:     viper.GetString("email_from"),
+				To:       viper.GetStringSlice("email_to"),
+			},
+			viper.GetString("email_digest"),
+			viper.GetString("email_weekday"),
+			MatchLocale(viper.GetString("email_locale")),
+			viper.GetString("template_dir"),
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		notifications.Notifiers = append(notifications.Notifiers, emails...)
+	}
+	if len(notifications.Notifiers) > 0 {
+		deadLetterFout, err := os.OpenFile(
+			viper.GetString("dead_letter_log"),
+			os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+			0600,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer deadLetterFout.Close()
+
+		notifications.DeadLetter = log.New(deadLetterFout, "", 0)
+		config.Notifications = notifications
+	}
+
+	selection := NewSelectionStore()
+	status := RefreshStatus{}
+	admin := LoadAdminState(viper.GetString("admin_file"))
+	candidates := LoadCandidateList(viper.GetString("candidates_dir"))
+
+	if *replay != "" {
+		date, err := time.ParseInLocation(timeFormat, *replay, location)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pick, ok := ReplaySelection(config, admin, date)
+		if !ok {
+			log.Fatalf("No pick could be made for %s", *replay)
+		}
+		fmt.Printf(
+			"%s: %s (%s), %+.1f%% from %s to %s\n",
+			*replay,
+			pick.Dataset.Description,
+			pick.Dataset.Key(),
+			100*pick.Gain(),
+			pick.OldTime.Format(timeFormat),
+			pick.NewTime.Format(timeFormat),
+		)
+		return
+	}
+
+	// With several replicas, only the one holding the lease crawls
+	var coordinator *Coordinator
+	if backend := viper.GetString("lease_backend"); backend != "" {
+		target := viper.GetString("lease_file")
+		if backend == "sql" {
+			target = viper.GetString("lease_database_url")
+		}
+		ttl := viper.GetDuration("lease_ttl")
+		lease, err := NewLease(backend, target, ttl)
+		if err != nil {
+			log.Fatal(err)
+		}
+		coordinator = &Coordinator{
+			Lease: lease,
+			Renew: ttl / 3,
+			Poll:  viper.GetDuration("lease_poll"),
+		}
+
+		// Overrides can be posted to any replica, but only the
+		// leader selects
+		admin.Share(config.Cache, config.TempFile+"-admin")
+	}
+
+	refresh := func() {
+		coordinator.Run(
+			func() {
+				SelectSynchronously(
+					config,
+					selection,
+					admin,
+					candidates,
+				)
+			},
+			func() {
+				FollowSelection(
+					config,
+					coordinator.Poll,
+					schedule.Next(clock.Now()),
+					selection,
+					candidates,
+				)
+			},
+		)
+	}
+
+	if cached, err := LoadBackup(config.Cache, config.TempFile); os.IsNotExist(err) {
+		log.Println("No cache file found, loading synchronously")
+		refresh()
+	} else if err != nil {
+		log.Println("Error reading cache file, loading synchronously:", err)
+		refresh()
+	} else if clock.Now().After(schedule.Next(cached.Time)) {
+		// Followers still need the stale selection to tell when the
+		// leader has stored a newer one
+		selection.Store(cached)
+		log.Println("Cache file is too old, loading synchronously")
+		refresh()
+	} else {
+		selection.Store(cached)
+		log.Println("Loaded cache from", cached.Time)
+	}
+
+	go RunSchedule(clock, schedule, &status, nil, refresh)
+	if coordinator != nil {
+		// Pins and refreshes on other replicas store selections
+		// between scheduled runs
+		go WatchSelection(config, coordinator.Poll, selection, candidates, nil)
+	}
 
-	log.Printf("Starting server on port %d\n", viper.GetInt("port"))
 	http.Handle(
 		"/",
-		Middleware(IndexHandler(config, &selection, &selectionLock)),
+		Middleware(IndexHandler(config, selection)),
+	)
+	http.Handle(
+		"/status",
+		Middleware(StatusHandler(config, selection, &status)),
+	)
+	http.Handle(
+		"/leaderboard",
+		Middleware(LeaderboardHandler(config, candidates)),
+	)
+	http.Handle(
+		"/api/v1/candidates",
+		Middleware(CandidatesHandler(candidates)),
 	)
-	http.Handle("/favicon.ico", Middleware(FaviconHandler()))
-	log.Fatal(
-		http.ListenAndServe(fmt.Sprintf(":%d", viper.GetInt("port")), nil),
+	http.Handle(
+		"/og.png",
+		Middleware(CardHandler(config, selection)),
+	)
+	http.Handle("/feed.atom", Middleware(FeedHandler(config, "atom")))
+	http.Handle("/feed.rss", Middleware(FeedHandler(config, "rss")))
+	http.Handle("/pick/", Middleware(PickHandler(config)))
+	http.Handle(
+		"/calculator",
+		Middleware(CalculatorHandler(config, selection)),
 	)
+	http.Handle(
+		"/api/v1/calculator",
+		Middleware(CalculatorAPIHandler(config, selection)),
+	)
+	static := NewStaticFiles(viper.GetString("static_dir"))
+	http.Handle("/static/", Middleware(static.Handler("/static/")))
+	for _, name := range []string{
+		"/favicon.ico",
+		"/robots.txt",
+		"/manifest.webmanifest",
+	} {
+		http.Handle(name, Middleware(static.Handler("/")))
+	}
+
+	if config.AdminToken != "" {
+		auditFout, err := os.OpenFile(
+			viper.GetString("audit_log"),
+			os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+			0600,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer auditFout.Close()
+
+		audit := log.New(auditFout, "", log.LstdFlags|log.LUTC)
+		http.Handle(
+			"/admin/",
+			Middleware(
+				AdminHandler(
+					config,
+					selection,
+					admin,
+					candidates,
+					refresh,
+					audit,
+				),
+			),
+		)
+	} else {
+		log.Println("No admin token set, admin endpoints disabled")
+	}
+
+	tlsConfig := TLSConfig{
+		CertFile:       viper.GetString("tls_cert_file"),
+		KeyFile:        viper.GetString("tls_key_file"),
+		ACMEDomains:    viper.GetStringSlice("acme_domains"),
+		ACMEDirectory:  viper.GetString("acme_directory"),
+		ACMEEmail:      viper.GetString("acme_email"),
+		ACMECacheDir:   viper.GetString("acme_cache_dir"),
+		ACMECAFile:     viper.GetString("acme_ca_file"),
+		MinVersion:     viper.GetString("tls_min_version"),
+		HSTSMaxAge:     viper.GetDuration("hsts_max_age"),
+		HSTSSubdomains: viper.GetBool("hsts_include_subdomains"),
+	}
+	serverConfig := ServerConfig{
+		ReadHeaderTimeout: viper.GetDuration("read_header_timeout"),
+		ReadTimeout:       viper.GetDuration("read_timeout"),
+		WriteTimeout:      viper.GetDuration("write_timeout"),
+		IdleTimeout:       viper.GetDuration("idle_timeout"),
+		MaxHeaderBytes:    viper.GetInt("max_header_bytes"),
+	}
+	httpAddr := fmt.Sprintf(":%d", viper.GetInt("port"))
+	if !tlsConfig.Enabled() {
+		log.Printf("Starting server on port %d\n", viper.GetInt("port"))
+		log.Fatal(serverConfig.Server(httpAddr, nil).ListenAndServe())
+	}
+
+	server, redirect, err := tlsConfig.Servers(
+		http.DefaultServeMux,
+		viper.GetInt("https_port"),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		log.Printf("Redirecting port %d to HTTPS\n", viper.GetInt("port"))
+		log.Fatal(
+			serverConfig.Server(httpAddr, Middleware(redirect)).ListenAndServe(),
+		)
+	}()
+	log.Printf("Starting server on port %d\n", viper.GetInt("https_port"))
+	log.Fatal(serverConfig.Apply(server).ListenAndServeTLS("", ""))
 }
@@ -0,0 +1,311 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+// Message catalogs for each locale.  Messages are HTML and fmt format
+// strings, using explicit argument indexes so translations can reorder
+// them.  Anything missing from a catalog falls back to English.
+
+var messagesEN map[string]string = map[string]string{
+	"footer.source":   "You can find the source code for this project at %[1]s.",
+	"footer.contact":  "If you find erroneous data displayed here, please let me know by writing to %[1]s.",
+	"footer.language": "Language:",
+
+	"index.pick":            "Today's Hindsight Investment: <strong>%[1]s</strong>",
+	"index.pick_on":         "Hindsight Investment for %[1]s: <strong>%[2]s</strong>",
+	"index.permalink":       "Permalink",
+	"index.feed":            "Feed",
+	"index.chart":           "Value from %[1]s to %[2]s",
+	"index.change":          "Between the close of trading on %[1]s and %[2]s, <strong>%[3]s</strong> increased in value by <strong>%[4]s</strong>.",
+	"index.change_calendar": "Between the close of trading on %[1]s and %[2]s (%[5]s %[6]s trading days), <strong>%[3]s</strong> increased in value by <strong>%[4]s</strong>.",
+	"index.calculator":      "What would $%[1]s have become?",
+	"index.why":             "Why?",
+	"index.why_text": "The purpose of this page is to demonstrate hind-sight " +
+		"bias.  It's easy to blame ourselves for not having taken " +
+		"advantage of an opportunity that seems obvious in retrospect, " +
+		"but the reality is that every day we miss countless investments " +
+		"which, unbeknownst to anyone at the time, are destined to " +
+		"increase in value drastically.  Every day this page will display " +
+		"one of the higher-performing securities from a year ago, " +
+		"hopefully illustrating the fact that most people had no idea " +
+		"what would come next.",
+
+	"status.title":       "Status",
+	"status.heading":     "%[1]s Status",
+	"status.current":     "Current selection",
+	"status.selected_at": "Selected at",
+	"status.schedule":    "Refresh schedule",
+	"status.next":        "Next refresh",
+	"status.never":       "never",
+	"status.in_progress": "Refresh in progress",
+	"status.yes":         "yes",
+
+	"leaderboard.title":    "Leaderboard",
+	"leaderboard.heading":  "%[1]s Leaderboard",
+	"leaderboard.intro":    "Every security considered on %[1]s, ranked by change in value.",
+	"leaderboard.database": "Database",
+	"leaderboard.all":      "All",
+	"leaderboard.date":     "Date",
+	"leaderboard.filter":   "Filter",
+
+	"column.rank":        "Rank",
+	"column.symbol":      "Symbol",
+	"column.description": "Description",
+	"column.database":    "Database",
+	"column.from":        "From",
+	"column.to":          "To",
+	"column.change":      "Change",
+
+	"calculator.title":        "Calculator",
+	"calculator.heading":      "What Would It Have Become?",
+	"calculator.amount":       "Amount invested",
+	"calculator.date":         "Pick from date (blank for today's)",
+	"calculator.fee_percent":  "Fee per trade, percent",
+	"calculator.fee_flat":     "Fee per trade, flat",
+	"calculator.local":        "Also show amounts in the foreign currency (exchange rates only)",
+	"calculator.submit":       "Calculate",
+	"calculator.result":       "%[1]s invested in <strong>%[2]s</strong> on %[3]s would have been worth <strong>%[4]s</strong> on %[5]s, a %[6]s return after %[7]s in fees.",
+	"calculator.result_local": "In %[1]s, that's %[2]s becoming %[3]s (%[4]s).",
+	"calculator.no_pick":      "No pick found for %[1]s",
+
+	"feed.description":   "A stock that, in hindsight, you should have bought a year ago.",
+	"feed.entry_title":   "%[1]s: up %[2]s",
+	"feed.entry_summary": "Between the close of trading on %[4]s and %[5]s, %[1]s (%[2]s) increased in value by %[3]s.",
+
+	"email.daily_subject":  "%[1]s: %[2]s",
+	"email.daily_heading":  "Today's %[1]s pick",
+	"email.weekly_subject": "%[1]s: the week in hindsight",
+	"email.weekly_heading": "The week in hindsight from %[1]s",
+}
+
+var messagesDE map[string]string = map[string]string{
+	"footer.source":   "Den Quellcode dieses Projekts finden Sie unter %[1]s.",
+	"footer.contact":  "Falls Ihnen hier fehlerhafte Daten auffallen, schreiben Sie mir bitte an %[1]s.",
+	"footer.language": "Sprache:",
+
+	"index.pick":            "Heutige Investition im Rückblick: <strong>%[1]s</strong>",
+	"index.pick_on":         "Investition im Rückblick vom %[1]s: <strong>%[2]s</strong>",
+	"index.permalink":       "Permalink",
+	"index.feed":            "Feed",
+	"index.chart":           "Wert vom %[1]s bis zum %[2]s",
+	"index.change":          "Zwischen Handelsschluss am %[1]s und am %[2]s ist der Wert von <strong>%[3]s</strong> um <strong>%[4]s</strong> gestiegen.",
+	"index.change_calendar": "Zwischen Handelsschluss am %[1]s und am %[2]s (%[5]s Handelstage laut %[6]s-Kalender) ist der Wert von <strong>%[3]s</strong> um <strong>%[4]s</strong> gestiegen.",
+	"index.calculator":      "Was wäre aus %[1]s\u00a0$ geworden?",
+	"index.why":             "Warum?",
+	"index.why_text": "Diese Seite soll den Rückschaufehler veranschaulichen.  " +
+		"Man macht sich leicht Vorwürfe, eine Gelegenheit nicht genutzt " +
+		"zu haben, die im Nachhinein offensichtlich erscheint.  " +
+		"Tatsächlich verpassen wir aber jeden Tag unzählige " +
+		"Investitionen, deren Wert, ohne dass es damals irgendjemand " +
+		"ahnte, drastisch steigen wird.  Jeden Tag zeigt diese Seite " +
+		"eines der erfolgreicheren Wertpapiere des vergangenen Jahres " +
+		"und verdeutlicht hoffentlich, dass die meisten Menschen keine " +
+		"Ahnung hatten, was kommen würde.",
+
+	"status.title":       "Status",
+	"status.heading":     "%[1]s – Status",
+	"status.current":     "Aktuelle Auswahl",
+	"status.selected_at": "Ausgewählt am",
+	"status.schedule":    "Aktualisierungsplan",
+	"status.next":        "Nächste Aktualisierung",
+	"status.never":       "nie",
+	"status.in_progress": "Aktualisierung läuft",
+	"status.yes":         "ja",
+
+	"leaderboard.title":    "Rangliste",
+	"leaderboard.heading":  "%[1]s – Rangliste",
+	"leaderboard.intro":    "Alle am %[1]s berücksichtigten Wertpapiere, sortiert nach Wertänderung.",
+	"leaderboard.database": "Datenbank",
+	"leaderboard.all":      "Alle",
+	"leaderboard.date":     "Datum",
+	"leaderboard.filter":   "Filtern",
+
+	"column.rank":        "Rang",
+	"column.symbol":      "Symbol",
+	"column.description": "Beschreibung",
+	"column.database":    "Datenbank",
+	"column.from":        "Von",
+	"column.to":          "Bis",
+	"column.change":      "Änderung",
+
+	"calculator.title":        "Rechner",
+	"calculator.heading":      "Was wäre daraus geworden?",
+	"calculator.amount":       "Investierter Betrag",
+	"calculator.date":         "Auswahl vom Datum (leer für heute)",
+	"calculator.fee_percent":  "Gebühr pro Transaktion in Prozent",
+	"calculator.fee_flat":     "Pauschale Gebühr pro Transaktion",
+	"calculator.local":        "Beträge zusätzlich in der Fremdwährung anzeigen (nur Wechselkurse)",
+	"calculator.submit":       "Berechnen",
+	"calculator.result":       "%[1]s, am %[3]s in <strong>%[2]s</strong> investiert, wären am %[5]s <strong>%[4]s</strong> wert gewesen, eine Rendite von %[6]s nach %[7]s Gebühren.",
+	"calculator.result_local": "In %[1]s wären aus %[2]s %[3]s geworden (%[4]s).",
+	"calculator.no_pick":      "Keine Auswahl für %[1]s gefunden",
+
+	"feed.description":   "Ein Wertpapier, das man im Rückblick vor einem Jahr hätte kaufen sollen.",
+	"feed.entry_title":   "%[1]s: plus %[2]s",
+	"feed.entry_summary": "Zwischen Handelsschluss am %[4]s und am %[5]s ist der Wert von %[1]s (%[2]s) um %[3]s gestiegen.",
+
+	"email.daily_subject":  "%[1]s: %[2]s",
+	"email.daily_heading":  "Die heutige Auswahl von %[1]s",
+	"email.weekly_subject": "%[1]s: die Woche im Rückblick",
+	"email.weekly_heading": "Die Woche im Rückblick von %[1]s",
+}
+
+var messagesFR map[string]string = map[string]string{
+	"footer.source":   "Le code source de ce projet est disponible sur %[1]s.",
+	"footer.contact":  "Si vous remarquez des données erronées, merci de me le signaler en écrivant à %[1]s.",
+	"footer.language": "Langue\u00a0:",
+
+	"index.pick":            "L'investissement rétrospectif du jour\u00a0: <strong>%[1]s</strong>",
+	"index.pick_on":         "L'investissement rétrospectif du %[1]s\u00a0: <strong>%[2]s</strong>",
+	"index.permalink":       "Lien permanent",
+	"index.feed":            "Flux",
+	"index.chart":           "Valeur du %[1]s au %[2]s",
+	"index.change":          "Entre la clôture du %[1]s et celle du %[2]s, la valeur de <strong>%[3]s</strong> a augmenté de <strong>%[4]s</strong>.",
+	"index.change_calendar": "Entre la clôture du %[1]s et celle du %[2]s (%[5]s jours de bourse selon le calendrier %[6]s), la valeur de <strong>%[3]s</strong> a augmenté de <strong>%[4]s</strong>.",
+	"index.calculator":      "Que seraient devenus %[1]s\u00a0$\u00a0?",
+	"index.why":             "Pourquoi\u00a0?",
+	"index.why_text": "Cette page a pour but d'illustrer le biais " +
+		"rétrospectif.  Il est facile de se reprocher de ne pas avoir " +
+		"saisi une occasion qui semble évidente après coup, mais en " +
+		"réalité, nous passons chaque jour à côté d'innombrables " +
+		"investissements qui, sans que personne ne le sache à l'époque, " +
+		"vont prendre énormément de valeur.  Chaque jour, cette page " +
+		"présente l'un des titres les plus performants de l'année " +
+		"écoulée, en espérant montrer que la plupart des gens n'avaient " +
+		"aucune idée de ce qui allait se passer.",
+
+	"status.title":       "État",
+	"status.heading":     "%[1]s – État",
+	"status.current":     "Sélection actuelle",
+	"status.selected_at": "Sélectionnée le",
+	"status.schedule":    "Calendrier d'actualisation",
+	"status.next":        "Prochaine actualisation",
+	"status.never":       "jamais",
+	"status.in_progress": "Actualisation en cours",
+	"status.yes":         "oui",
+
+	"leaderboard.title":    "Classement",
+	"leaderboard.heading":  "%[1]s – Classement",
+	"leaderboard.intro":    "Tous les titres examinés le %[1]s, classés selon leur variation de valeur.",
+	"leaderboard.database": "Base de données",
+	"leaderboard.all":      "Toutes",
+	"leaderboard.date":     "Date",
+	"leaderboard.filter":   "Filtrer",
+
+	"column.rank":        "Rang",
+	"column.symbol":      "Symbole",
+	"column.description": "Description",
+	"column.database":    "Base de données",
+	"column.from":        "Du",
+	"column.to":          "Au",
+	"column.change":      "Variation",
+
+	"calculator.title":        "Calculateur",
+	"calculator.heading":      "Qu'est-ce que cela serait devenu\u00a0?",
+	"calculator.amount":       "Montant investi",
+	"calculator.date":         "Sélection du (vide pour aujourd'hui)",
+	"calculator.fee_percent":  "Frais par opération, en pourcentage",
+	"calculator.fee_flat":     "Frais fixes par opération",
+	"calculator.local":        "Afficher aussi les montants dans la devise étrangère (taux de change uniquement)",
+	"calculator.submit":       "Calculer",
+	"calculator.result":       "%[1]s investis dans <strong>%[2]s</strong> le %[3]s auraient valu <strong>%[4]s</strong> le %[5]s, soit un rendement de %[6]s après %[7]s de frais.",
+	"calculator.result_local": "En %[1]s, %[2]s seraient devenus %[3]s (%[4]s).",
+	"calculator.no_pick":      "Aucune sélection trouvée pour le %[1]s",
+
+	"feed.description":   "Un titre qu'il aurait fallu acheter il y a un an, avec le recul.",
+	"feed.entry_title":   "%[1]s\u00a0: +%[2]s",
+	"feed.entry_summary": "Entre la clôture du %[4]s et celle du %[5]s, la valeur de %[1]s (%[2]s) a augmenté de %[3]s.",
+
+	"email.daily_subject":  "%[1]s\u00a0: %[2]s",
+	"email.daily_heading":  "La sélection du jour de %[1]s",
+	"email.weekly_subject": "%[1]s\u00a0: la semaine en rétrospective",
+	"email.weekly_heading": "La semaine en rétrospective de %[1]s",
+}
+
+var messagesES map[string]string = map[string]string{
+	"footer.source":   "Puedes encontrar el código fuente de este proyecto en %[1]s.",
+	"footer.contact":  "Si encuentras datos erróneos aquí, avísame escribiendo a %[1]s.",
+	"footer.language": "Idioma:",
+
+	"index.pick":            "La inversión retrospectiva de hoy: <strong>%[1]s</strong>",
+	"index.pick_on":         "La inversión retrospectiva del %[1]s: <strong>%[2]s</strong>",
+	"index.permalink":       "Enlace permanente",
+	"index.feed":            "Feed",
+	"index.change":          "Entre el cierre de la sesión del %[1]s y el del %[2]s, el valor de <strong>%[3]s</strong> aumentó un <strong>%[4]s</strong>.",
+	"index.change_calendar": "Entre el cierre de la sesión del %[1]s y el del %[2]s (%[5]s sesiones según el calendario %[6]s), el valor de <strong>%[3]s</strong> aumentó un <strong>%[4]s</strong>.",
+	"index.calculator":      "¿En qué se habrían convertido %[1]s\u00a0$?",
+	"index.why":             "¿Por qué?",
+	"index.why_text": "El propósito de esta página es mostrar el sesgo " +
+		"retrospectivo.  Es fácil culparnos por no haber aprovechado una " +
+		"oportunidad que parece obvia a posteriori, pero lo cierto es " +
+		"que cada día dejamos pasar innumerables inversiones que, sin " +
+		"que nadie lo supiera en su momento, están destinadas a " +
+		"revalorizarse enormemente.  Cada día esta página muestra uno de " +
+		"los valores con mejor rendimiento del último año, con la " +
+		"esperanza de ilustrar que la mayoría de la gente no tenía ni " +
+		"idea de lo que iba a pasar.",
+
+	"status.title":       "Estado",
+	"status.heading":     "%[1]s – Estado",
+	"status.current":     "Selección actual",
+	"status.selected_at": "Seleccionada el",
+	"status.schedule":    "Programa de actualización",
+	"status.next":        "Próxima actualización",
+	"status.never":       "nunca",
+	"status.in_progress": "Actualización en curso",
+	"status.yes":         "sí",
+
+	"leaderboard.title":    "Clasificación",
+	"leaderboard.heading":  "%[1]s – Clasificación",
+	"leaderboard.intro":    "Todos los valores considerados el %[1]s, ordenados por variación de valor.",
+	"leaderboard.database": "Base de datos",
+	"leaderboard.all":      "Todas",
+	"leaderboard.date":     "Fecha",
+	"leaderboard.filter":   "Filtrar",
+
+	"column.rank":        "Puesto",
+	"column.symbol":      "Símbolo",
+	"column.description": "Descripción",
+	"column.database":    "Base de datos",
+	"column.from":        "Desde",
+	"column.to":          "Hasta",
+	"column.change":      "Variación",
+
+	"calculator.title":        "Calculadora",
+	"calculator.heading":      "¿En qué se habría convertido?",
+	"calculator.amount":       "Cantidad invertida",
+	"calculator.date":         "Selección del día (vacío para hoy)",
+	"calculator.fee_percent":  "Comisión por operación, en porcentaje",
+	"calculator.fee_flat":     "Comisión fija por operación",
+	"calculator.local":        "Mostrar también las cantidades en la moneda extranjera (solo tipos de cambio)",
+	"calculator.submit":       "Calcular",
+	"calculator.result":       "%[1]s invertidos en <strong>%[2]s</strong> el %[3]s habrían valido <strong>%[4]s</strong> el %[5]s, una rentabilidad del %[6]s tras %[7]s en comisiones.",
+	"calculator.result_local": "En %[1]s, %[2]s se habrían convertido en %[3]s (%[4]s).",
+	"calculator.no_pick":      "No se encontró ninguna selección para el %[1]s",
+
+	"feed.description":   "Un valor que, en retrospectiva, deberías haber comprado hace un año.",
+	"feed.entry_title":   "%[1]s: +%[2]s",
+	"feed.entry_summary": "Entre el cierre de la sesión del %[4]s y el del %[5]s, el valor de %[1]s (%[2]s) aumentó un %[3]s.",
+
+	"email.daily_subject":  "%[1]s: %[2]s",
+	"email.daily_heading":  "La selección de hoy de %[1]s",
+	"email.weekly_subject": "%[1]s: la semana en retrospectiva",
+	"email.weekly_heading": "La semana en retrospectiva de %[1]s",
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Notifier tells something outside the site about a new pick.
+type Notifier interface {
+	Name() string
+	Notify(selection DailySelection) error
+}
+
+// PermanentError marks a notification failure that retrying won't
+// fix, like a receiver rejecting the payload.
+type PermanentError struct {
+	Err error
+}
+
+func (e PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Notifications sends each new pick to every notifier, retrying
+// failures with exponential backoff.  Anything that still fails is
+// written to the dead-letter log so it can be resent by hand.  The
+// backoff waits on Clock, or the system clock if it's nil.
+type Notifications struct {
+	Notifiers  []Notifier
+	Retries    int
+	Backoff    time.Duration
+	DeadLetter *log.Logger
+	Clock      Clock
+
+	wait sync.WaitGroup
+}
+
+// Send notifies in the background, so a slow receiver can't hold up
+// the selection.
+func (n *Notifications) Send(selection DailySelection) {
+	if n == nil {
+		return
+	}
+
+	for _, v := range n.Notifiers {
+		n.wait.Add(1)
+		go func(notifier Notifier) {
+			defer n.wait.Done()
+			n.send(notifier, selection)
+		}(v)
+	}
+}
+
+// Wait blocks until every notification in flight has finished.
+func (n *Notifications) Wait() {
+	if n != nil {
+		n.wait.Wait()
+	}
+}
+
+func (n *Notifications) send(notifier Notifier, selection DailySelection) {
+	clock := n.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	backoff := n.Backoff
+	var err error
+	for attempt := 0; attempt <= n.Retries; attempt++ {
+		if attempt > 0 {
+			clock.Sleep(backoff)
+			backoff *= 2
+		}
+
+		err = notifier.Notify(selection)
+		if err == nil {
+			return
+		}
+		log.Printf("Error notifying %s: %s", notifier.Name(), err)
+		if _, ok := err.(PermanentError); ok {
+			break
+		}
+	}
+
+	if n.DeadLetter == nil {
+		return
+	}
+	selection.Series = nil
+	entry, jsonErr := json.Marshal(
+		struct {
+			Notifier  string         `json:"notifier"`
+			Error     string         `json:"error"`
+			Selection DailySelection `json:"selection"`
+		}{notifier.Name(), err.Error(), selection},
+	)
+	if jsonErr != nil {
+		log.Println("Error writing dead letter:", jsonErr)
+		return
+	}
+	n.DeadLetter.Println(string(entry))
+}
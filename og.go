@@ -0,0 +1,266 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cardWidth     = 1200
+	cardHeight    = 630
+	cardMargin    = 60
+	maxCachedCard = 32
+)
+
+var (
+	cardBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	cardText       = color.RGBA{0x22, 0x22, 0x22, 0xff}
+	cardMuted      = color.RGBA{0x55, 0x55, 0x55, 0xff}
+	cardAccent     = color.RGBA{0x22, 0xaa, 0x66, 0xff}
+)
+
+// CardCache holds rendered share cards, so each pick's is only drawn
+// once.
+type CardCache struct {
+	lock  sync.Mutex
+	cards map[string][]byte
+}
+
+func (c *CardCache) For(config Config, selection DailySelection) []byte {
+	key := selection.Dataset.Key() + "@" + selection.Time.String()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if card, ok := c.cards[key]; ok {
+		return card
+	}
+
+	// Old picks are rarely shared, so there's no point being clever
+	// about which ones to drop
+	if c.cards == nil || len(c.cards) >= maxCachedCard {
+		c.cards = map[string][]byte{}
+	}
+
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, RenderCard(config, selection)); err != nil {
+		panic(err)
+	}
+	c.cards[key] = buf.Bytes()
+	return c.cards[key]
+}
+
+// RenderCard draws the share card for a pick.  It only uses the
+// built-in bitmap font, scaled up, so there's nothing to install.
+func RenderCard(config Config, selection DailySelection) image.Image {
+	card := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	draw.Draw(
+		card,
+		card.Bounds(),
+		image.NewUniform(cardBackground),
+		image.Point{},
+		draw.Src,
+	)
+	draw.Draw(
+		card,
+		image.Rect(0, 0, cardWidth, 16),
+		image.NewUniform(cardAccent),
+		image.Point{},
+		draw.Src,
+	)
+
+	locale := Locales["en"]
+	y := cardMargin
+	y = drawCardText(card, config.Site.Title, y, 3, cardMuted)
+	y += 30
+	for _, line := range wrapCardText(selection.Dataset.Description, 5, 2) {
+		y = drawCardText(card, line, y, 5, cardText)
+	}
+	y += 10
+	y = drawCardText(card, selection.Dataset.Key(), y, 3, cardMuted)
+	y += 40
+
+	percent := locale.Percent(100*selection.Gain(), 0)
+	if selection.Gain() >= 0 {
+		percent = "+" + percent
+	}
+	y = drawCardText(card, percent, y, 12, cardAccent)
+	y += 20
+	drawCardText(
+		card,
+		locale.Date(selection.OldTime)+" - "+locale.Date(selection.NewTime),
+		y,
+		3,
+		cardMuted,
+	)
+
+	return card
+}
+
+// drawCardText draws a line at the left margin with its top at y,
+// scaling the font up by scale, and returns the y below it.
+func drawCardText(
+	card *image.RGBA,
+	text string,
+	y int,
+	scale int,
+	c color.Color,
+) int {
+	if text == "" {
+		return y
+	}
+
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil()
+	height := face.Height
+	line := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	drawer := font.Drawer{
+		Dst:  line,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(0, face.Ascent),
+	}
+	drawer.DrawString(text)
+
+	target := image.Rect(
+		cardMargin,
+		y,
+		cardMargin+width*scale,
+		y+height*scale,
+	)
+	draw.NearestNeighbor.Scale(card, target, line, line.Bounds(), draw.Over, nil)
+	return target.Max.Y
+}
+
+// wrapCardText splits text into at most maxLines lines that fit the
+// card at the given scale, ending with an ellipsis if it's cut short.
+func wrapCardText(text string, scale int, maxLines int) []string {
+	perLine := (cardWidth - 2*cardMargin) / (basicfont.Face7x13.Advance * scale)
+
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len([]rune(line+" "+word)) > perLine {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] += "..."
+	}
+	for i, v := range lines {
+		if runes := []rune(v); len(runes) > perLine {
+			lines[i] = string(runes[:perLine-3]) + "..."
+		}
+	}
+	return lines
+}
+
+// CardHandler serves the share card for the current pick, or for a
+// past one given ?date=YYYY-MM-DD.
+func CardHandler(
+	config Config,
+	selection *SelectionStore,
+) http.Handler {
+	cards := &CardCache{}
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			pick := selection.Load()
+
+			if date := r.URL.Query().Get("date"); date != "" {
+				var ok bool
+				pick, ok = LoadHistory(config.Cache, config.HistoryFile).On(
+					date,
+					config.Schedule.Location,
+				)
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+			}
+
+			card := cards.For(config, pick)
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+			http.ServeContent(w, r, "", pick.Time, bytes.NewReader(card))
+		},
+	)
+}
+
+// ShareData adds the Open Graph and Twitter card details for a pick
+// to a page's data.  Links point at the pick's permalink and dated
+// card, since sites cache previews by URL.  They have to be absolute,
+// so with no base they're left out.
+func ShareData(
+	config Config,
+	base string,
+	locale *Locale,
+	selection DailySelection,
+	data map[string]interface{},
+) {
+	date := selection.Time.In(config.Schedule.Location).Format(timeFormat)
+	percent := locale.Percent(100*selection.Gain(), 0)
+
+	share := map[string]interface{}{
+		"Title": locale.Text(
+			"feed.entry_title",
+			selection.Dataset.Description,
+			percent,
+		),
+		"Description": locale.Text(
+			"feed.entry_summary",
+			selection.Dataset.Description,
+			selection.Dataset.Key(),
+			percent,
+			locale.Date(selection.OldTime),
+			locale.Date(selection.NewTime),
+		),
+		"ImageWidth":  cardWidth,
+		"ImageHeight": cardHeight,
+		"Published":   selection.Time.UTC().Format(time.RFC3339),
+	}
+	if base != "" {
+		share["URL"] = base + PermalinkPath(date)
+		share["Image"] = base + "/og.png?date=" + date
+	}
+	data["share"] = share
+}
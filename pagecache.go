@@ -0,0 +1,145 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const maxCachedPages = 64
+
+// RenderedPage is a page rendered once and kept, both as is and
+// gzipped, to be served until the selection changes.
+type RenderedPage struct {
+	Body     []byte
+	Gzipped  []byte
+	ETag     string
+	Modified time.Time
+}
+
+// PageCache holds rendered pages by key, which should cover
+// everything that goes into the page: the selection and the locale.
+// Anything else from the request, like its Host, would let clients
+// fill the cache with variants and push the real pages out.
+type PageCache struct {
+	lock  sync.Mutex
+	pages map[string]*RenderedPage
+}
+
+// For returns the page for key, calling render to make it the first
+// time.
+func (c *PageCache) For(
+	key string,
+	modified time.Time,
+	render func(w io.Writer) error,
+) *RenderedPage {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if page, ok := c.pages[key]; ok {
+		return page
+	}
+
+	// Pages for old selections are never asked for again, so starting
+	// over is as good as anything
+	if c.pages == nil || len(c.pages) >= maxCachedPages {
+		c.pages = map[string]*RenderedPage{}
+	}
+
+	body := bytes.Buffer{}
+	if err := render(&body); err != nil {
+		panic(err)
+	}
+
+	gzipped := bytes.Buffer{}
+	gz, err := gzip.NewWriterLevel(&gzipped, gzip.BestCompression)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := gz.Write(body.Bytes()); err != nil {
+		panic(err)
+	}
+	if err := gz.Close(); err != nil {
+		panic(err)
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	page := &RenderedPage{
+		Body:     body.Bytes(),
+		Gzipped:  gzipped.Bytes(),
+		ETag:     hex.EncodeToString(sum[:16]),
+		Modified: modified,
+	}
+	c.pages[key] = page
+	return page
+}
+
+// SelectionKey identifies a selection for cache keys and ETags.
+func SelectionKey(selection DailySelection) string {
+	return selection.Dataset.Key() + "@" + selection.Time.UTC().String()
+}
+
+// ServePage sends a rendered page, gzipped if the client takes it,
+// with validators for conditional requests.  Caches may keep it until
+// expires, when the next selection is due; a zero expires means the
+// page may change at any moment.
+func ServePage(
+	w http.ResponseWriter,
+	r *http.Request,
+	page *RenderedPage,
+	now time.Time,
+	expires time.Time,
+) {
+	body, etag := page.Body, page.ETag
+
+	// Pages come in a language picked from the header or the cookie
+	w.Header().Set("Vary", "Accept-Encoding, Accept-Language, Cookie")
+	if acceptedEncodings(r.Header.Get("Accept-Encoding"))["gzip"] {
+		// The gzipped bytes are a different representation, so they
+		// need their own strong ETag
+		body, etag = page.Gzipped, etag+"-gzip"
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+
+	// A shared cache mustn't hand out someone else's ?lang= cookie
+	scope := "public"
+	if len(w.Header().Values("Set-Cookie")) > 0 {
+		scope = "private"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("ETag", `"`+etag+`"`)
+	if maxAge := int(expires.Sub(now).Seconds()); !expires.IsZero() && maxAge > 0 {
+		w.Header().Set(
+			"Cache-Control",
+			fmt.Sprintf("%s, max-age=%d", scope, maxAge),
+		)
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, "", page.Modified, bytes.NewReader(body))
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// SelectionPolicy chooses the day's pick from a list of candidates
+// sorted best performer first, drawing from random.  Pick returns
+// false if there's nothing to choose from.
+type SelectionPolicy interface {
+	Pick(candidates []DailySelection, random *rand.Rand) (DailySelection, bool)
+}
+
+// UniformPolicy gives each of the top N candidates an equal chance.
+type UniformPolicy struct {
+	TopN int
+}
+
+// RankWeightedPolicy favors better ranks linearly: the best of N
+// candidates is N times as likely as the worst.
+type RankWeightedPolicy struct {
+	TopN int
+}
+
+// ReturnWeightedPolicy weights each of the top N candidates by its
+// gain, so a 300% winner is three times as likely as a 100% one.
+type ReturnWeightedPolicy struct {
+	TopN int
+}
+
+// SoftmaxPolicy weights each of the top N candidates by
+// exp(gain / Temperature).  Low temperatures approach always picking
+// the best performer, high ones approach uniform.
+type SoftmaxPolicy struct {
+	TopN        int
+	Temperature float64
+}
+
+func NewSelectionPolicy(
+	name string,
+	topN int,
+	temperature float64,
+) (SelectionPolicy, error) {
+	switch name {
+	case "uniform":
+		return UniformPolicy{topN}, nil
+	case "rank":
+		return RankWeightedPolicy{topN}, nil
+	case "return":
+		return ReturnWeightedPolicy{topN}, nil
+	case "softmax":
+		return SoftmaxPolicy{topN, temperature}, nil
+	default:
+		return nil, fmt.Errorf("Unknown selection policy %q", name)
+	}
+}
+
+func (p UniformPolicy) Pick(
+	candidates []DailySelection,
+	random *rand.Rand,
+) (DailySelection, bool) {
+	candidates = topN(candidates, p.TopN)
+	if len(candidates) == 0 {
+		return DailySelection{}, false
+	}
+	return candidates[random.Intn(len(candidates))], true
+}
+
+func (p RankWeightedPolicy) Pick(
+	candidates []DailySelection,
+	random *rand.Rand,
+) (DailySelection, bool) {
+	candidates = topN(candidates, p.TopN)
+	weights := make([]float64, len(candidates))
+	for i := range candidates {
+		weights[i] = float64(len(candidates) - i)
+	}
+	return weightedPick(candidates, weights, random)
+}
+
+func (p ReturnWeightedPolicy) Pick(
+	candidates []DailySelection,
+	random *rand.Rand,
+) (DailySelection, bool) {
+	candidates = topN(candidates, p.TopN)
+	weights := make([]float64, len(candidates))
+	for i, v := range candidates {
+		weights[i] = math.Max(v.Gain(), 0)
+	}
+	return weightedPick(candidates, weights, random)
+}
+
+func (p SoftmaxPolicy) Pick(
+	candidates []DailySelection,
+	random *rand.Rand,
+) (DailySelection, bool) {
+	candidates = topN(candidates, p.TopN)
+	if len(candidates) == 0 {
+		return DailySelection{}, false
+	}
+	if p.Temperature <= 0 {
+		return candidates[0], true
+	}
+
+	// Candidates are sorted, so subtracting the first gain keeps every
+	// exponent at or below zero and avoids overflow
+	best := candidates[0].Gain()
+	weights := make([]float64, len(candidates))
+	for i, v := range candidates {
+		weights[i] = math.Exp((v.Gain() - best) / p.Temperature)
+	}
+	return weightedPick(candidates, weights, random)
+}
+
+func topN(candidates []DailySelection, n int) []DailySelection {
+	if n <= 0 || n > len(candidates) {
+		return candidates
+	}
+	return candidates[:n]
+}
+
+// weightedPick draws one candidate with probability proportional to
+// its weight.  Invalid weights count as zero, and if nothing is left
+// with any weight it falls back to a uniform draw.
+func weightedPick(
+	candidates []DailySelection,
+	weights []float64,
+	random *rand.Rand,
+) (DailySelection, bool) {
+	if len(candidates) == 0 {
+		return DailySelection{}, false
+	}
+
+	total := 0.0
+	for i, w := range weights {
+		if math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+			weights[i] = 0
+		}
+		total += weights[i]
+	}
+	if total <= 0 {
+		return candidates[random.Intn(len(candidates))], true
+	}
+
+	target := random.Float64() * total
+	for i, w := range weights {
+		target -= w
+		if target < 0 {
+			return candidates[i], true
+		}
+	}
+	return candidates[len(candidates)-1], true
+}
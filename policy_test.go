@@ -0,0 +1,216 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// policyCandidates makes candidates with the given gains, named by
+// their position.
+func policyCandidates(gains ...float64) []DailySelection {
+	out := []DailySelection{}
+	for i, v := range gains {
+		out = append(out, DailySelection{
+			Dataset: Dataset{"TEST", fmt.Sprint(i), fmt.Sprint("Test ", i)},
+			RequestResult: RequestResult{
+				OldValue: 100,
+				NewValue: 100 * (1 + v),
+			},
+		})
+	}
+	return out
+}
+
+// policyDraws picks n times, counting how often each candidate came
+// up.
+func policyDraws(
+	t *testing.T,
+	policy SelectionPolicy,
+	candidates []DailySelection,
+	n int,
+) map[string]int {
+	random := rand.New(rand.NewSource(1))
+	out := map[string]int{}
+	for i := 0; i < n; i++ {
+		pick, ok := policy.Pick(candidates, random)
+		if !ok {
+			t.Fatalf("%#v made no pick", policy)
+		}
+		out[pick.Dataset.Dataset]++
+	}
+	return out
+}
+
+// checkShares fails if any candidate's share of the draws is more
+// than tolerance from what's expected.
+func checkShares(
+	t *testing.T,
+	name string,
+	counts map[string]int,
+	expected []float64,
+	tolerance float64,
+) {
+	total := 0
+	for _, v := range counts {
+		total += v
+	}
+	for i, share := range expected {
+		got := float64(counts[fmt.Sprint(i)]) / float64(total)
+		if math.Abs(got-share) > tolerance {
+			t.Errorf("%s: candidate %d got %.3f, expected %.3f", name, i, got, share)
+		}
+	}
+	if len(counts) > len(expected) {
+		t.Errorf("%s: picked outside the top %d: %v", name, len(expected), counts)
+	}
+}
+
+var testPolicies = []SelectionPolicy{
+	UniformPolicy{3},
+	RankWeightedPolicy{3},
+	ReturnWeightedPolicy{3},
+	SoftmaxPolicy{3, 1},
+}
+
+func TestPoliciesEmpty(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, policy := range testPolicies {
+		if _, ok := policy.Pick(nil, random); ok {
+			t.Errorf("%#v picked from nothing", policy)
+		}
+		if _, ok := policy.Pick([]DailySelection{}, random); ok {
+			t.Errorf("%#v picked from an empty list", policy)
+		}
+	}
+}
+
+func TestPoliciesSingle(t *testing.T) {
+	for _, policy := range testPolicies {
+		counts := policyDraws(t, policy, policyCandidates(0.5), 10)
+		if counts["0"] != 10 {
+			t.Errorf("%#v didn't always pick the only candidate: %v", policy, counts)
+		}
+	}
+}
+
+func TestPoliciesFewerThanTopN(t *testing.T) {
+	for _, policy := range testPolicies {
+		counts := policyDraws(t, policy, policyCandidates(2, 1), 1000)
+		if counts["0"] == 0 || counts["1"] == 0 || len(counts) != 2 {
+			t.Errorf("%#v: expected both candidates, got %v", policy, counts)
+		}
+	}
+}
+
+func TestTopN(t *testing.T) {
+	candidates := policyCandidates(4, 3, 2, 1, 0.5)
+	cases := []struct {
+		n        int
+		expected int
+	}{
+		{0, 5},
+		{-1, 5},
+		{2, 2},
+		{5, 5},
+		{10, 5},
+	}
+	for _, c := range cases {
+		if got := len(topN(candidates, c.n)); got != c.expected {
+			t.Errorf("topN(%d) kept %d, expected %d", c.n, got, c.expected)
+		}
+	}
+
+	// No limit means every candidate is in the running
+	counts := policyDraws(t, UniformPolicy{0}, candidates, 5000)
+	checkShares(t, "uniform, no limit", counts, []float64{.2, .2, .2, .2, .2}, 0.03)
+}
+
+func TestPolicyDistributions(t *testing.T) {
+	candidates := policyCandidates(3, 1, 0.5, 0.1)
+	cases := []struct {
+		name     string
+		policy   SelectionPolicy
+		expected []float64
+	}{
+		{"uniform", UniformPolicy{3}, []float64{1. / 3, 1. / 3, 1. / 3}},
+		{"rank", RankWeightedPolicy{3}, []float64{3. / 6, 2. / 6, 1. / 6}},
+		{"return", ReturnWeightedPolicy{3}, []float64{3 / 4.5, 1 / 4.5, 0.5 / 4.5}},
+		{"softmax", SoftmaxPolicy{2, 1}, []float64{
+			1 / (1 + math.Exp(-2)),
+			math.Exp(-2) / (1 + math.Exp(-2)),
+		}},
+	}
+	for _, c := range cases {
+		counts := policyDraws(t, c.policy, candidates, 10000)
+		checkShares(t, c.name, counts, c.expected, 0.02)
+	}
+}
+
+func TestReturnWeightedPolicyLosses(t *testing.T) {
+	// Nothing gained, so nothing has any weight and every candidate
+	// is as likely as the others
+	candidates := policyCandidates(-0.1, -0.2, -0.5)
+	counts := policyDraws(t, ReturnWeightedPolicy{3}, candidates, 6000)
+	checkShares(t, "all losses", counts, []float64{1. / 3, 1. / 3, 1. / 3}, 0.03)
+
+	// Losses are weighted zero next to any gain
+	candidates = policyCandidates(0.5, -0.2)
+	counts = policyDraws(t, ReturnWeightedPolicy{2}, candidates, 100)
+	if counts["0"] != 100 {
+		t.Errorf("Expected only the gain to be picked, got %v", counts)
+	}
+}
+
+func TestSoftmaxPolicyTemperature(t *testing.T) {
+	candidates := policyCandidates(3, 1, 0.5)
+
+	for _, temperature := range []float64{0, -1} {
+		counts := policyDraws(t, SoftmaxPolicy{3, temperature}, candidates, 100)
+		if counts["0"] != 100 {
+			t.Errorf("Temperature %g: expected the best every time, got %v", temperature, counts)
+		}
+	}
+
+	counts := policyDraws(t, SoftmaxPolicy{3, 1e9}, candidates, 6000)
+	checkShares(t, "hot softmax", counts, []float64{1. / 3, 1. / 3, 1. / 3}, 0.03)
+
+	// Cold enough to underflow everything but the best, which is
+	// still picked rather than falling back to uniform
+	counts = policyDraws(t, SoftmaxPolicy{3, 1e-9}, candidates, 100)
+	if counts["0"] != 100 {
+		t.Errorf("Expected a cold softmax to pick the best, got %v", counts)
+	}
+}
+
+func TestWeightedPickInvalidWeights(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	candidates := policyCandidates(0, 0, 0)
+	for i := 0; i < 100; i++ {
+		weights := []float64{math.NaN(), math.Inf(1), 1}
+		pick, ok := weightedPick(candidates, weights, random)
+		if !ok || pick.Dataset.Dataset != "2" {
+			t.Fatalf("Expected only the finite weight to count, got %s", pick.Dataset.Dataset)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the networks whose forwarding headers are
+// believed.  The default is loopback and the private ranges, so a
+// proxy on the same host or network works out of the box.
+var TrustedProxies []*net.IPNet = mustParseCIDRs(
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+)
+
+// ProxyHeader names the one forwarding header the trusted proxies
+// set: "xff" for X-Forwarded-For, "forwarded" for RFC 7239 Forwarded,
+// or "x-real-ip".  The others are ignored, since a client can send
+// them itself and a proxy that only adds to one passes the rest along
+// untouched.
+var ProxyHeader string = "xff"
+
+// proxyHeaders reads the hops from each kind of forwarding header,
+// client first.
+var proxyHeaders map[string]func(http.Header) []string = map[string]func(http.Header) []string{
+	"xff":       xffHops,
+	"forwarded": forwardedHops,
+	"x-real-ip": realIPHops,
+}
+
+type contextKey int
+
+const clientIPKey contextKey = iota
+
+func ParseCIDRs(cidrs ...string) ([]*net.IPNet, error) {
+	out := []*net.IPNet{}
+	for _, v := range cidrs {
+		// A bare address trusts just that host
+		cidr := v
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid network %q", v)
+		}
+		out = append(out, network)
+	}
+	return out, nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	out, err := ParseCIDRs(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func trustedProxy(ip net.IP) bool {
+	for _, v := range TrustedProxies {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidProxyHeader reports whether name is a ProxyHeader setting.
+func ValidProxyHeader(name string) bool {
+	_, ok := proxyHeaders[name]
+	return ok
+}
+
+// ResolveClientIP works out who made a request.  Starting from the
+// connection's address, it walks back through the hops recorded in
+// ProxyHeader by each trusted proxy, stopping at the first address it
+// can't vouch for.
+func ResolveClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	client := net.ParseIP(host)
+	if client == nil {
+		return nil
+	}
+
+	hops := proxyHeaders[ProxyHeader](r.Header)
+	for i := len(hops) - 1; i >= 0 && trustedProxy(client); i-- {
+		hop := parseHop(hops[i])
+		if hop == nil {
+			break
+		}
+		client = hop
+	}
+	return client
+}
+
+func forwardedHops(header http.Header) []string {
+	hops := []string{}
+	values := header.Values("Forwarded")
+	if len(values) == 0 {
+		return hops
+	}
+	for _, element := range strings.Split(strings.Join(values, ","), ",") {
+		// Elements without a for= are kept as empty hops, so they
+		// still stop the walk
+		hop := ""
+		for _, pair := range strings.Split(element, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+				hop = strings.Trim(kv[1], `"`)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+func xffHops(header http.Header) []string {
+	hops := []string{}
+	values := header.Values("X-Forwarded-For")
+	if len(values) == 0 {
+		return hops
+	}
+	for _, v := range strings.Split(strings.Join(values, ","), ",") {
+		hops = append(hops, strings.TrimSpace(v))
+	}
+	return hops
+}
+
+func realIPHops(header http.Header) []string {
+	if v := header.Get("X-Real-IP"); v != "" {
+		return []string{strings.TrimSpace(v)}
+	}
+	return []string{}
+}
+
+// parseHop reads an address that may have a port and, for IPv6, be
+// in brackets.  Obfuscated identifiers and "unknown" give nil.
+func parseHop(hop string) net.IP {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
+
+func withClientIP(r *http.Request) *http.Request {
+	ip := ResolveClientIP(r)
+	if ip == nil {
+		return r
+	}
+	return r.WithContext(
+		context.WithValue(r.Context(), clientIPKey, ip.String()),
+	)
+}
+
+// ClientIP is the address Middleware resolved for a request, or the
+// connection's address for requests that didn't go through it.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
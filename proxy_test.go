@@ -0,0 +1,230 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	saved := ProxyHeader
+	t.Cleanup(func() {
+		ProxyHeader = saved
+	})
+
+	cases := []struct {
+		name     string
+		source   string
+		remote   string
+		header   map[string]string
+		expected string
+	}{
+		{
+			"no proxy",
+			"xff",
+			"203.0.113.9:5000",
+			nil,
+			"203.0.113.9",
+		},
+		{
+			"untrusted peer can't claim another address",
+			"xff",
+			"203.0.113.9:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			"203.0.113.9",
+		},
+		{
+			"trusted proxy",
+			"xff",
+			"10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			"198.51.100.1",
+		},
+		{
+			"hops the client added before the proxy are ignored",
+			"xff",
+			"10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "10.0.0.7, 198.51.100.1"},
+			"198.51.100.1",
+		},
+		{
+			"chain of trusted proxies",
+			"xff",
+			"10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.2"},
+			"198.51.100.1",
+		},
+		{
+			"Forwarded is ignored with xff",
+			"xff",
+			"10.0.0.1:5000",
+			map[string]string{
+				"Forwarded":       "for=192.0.2.66",
+				"X-Forwarded-For": "198.51.100.1",
+			},
+			"198.51.100.1",
+		},
+		{
+			"X-Real-IP is ignored with xff",
+			"xff",
+			"10.0.0.1:5000",
+			map[string]string{"X-Real-IP": "192.0.2.66"},
+			"10.0.0.1",
+		},
+		{
+			"Forwarded",
+			"forwarded",
+			"10.0.0.1:5000",
+			map[string]string{"Forwarded": `for=198.51.100.1;proto=https`},
+			"198.51.100.1",
+		},
+		{
+			"X-Forwarded-For is ignored with forwarded",
+			"forwarded",
+			"10.0.0.1:5000",
+			map[string]string{
+				"Forwarded":       "for=198.51.100.1",
+				"X-Forwarded-For": "192.0.2.66",
+			},
+			"198.51.100.1",
+		},
+		{
+			"Forwarded IPv6 in brackets with a port",
+			"forwarded",
+			"[::1]:5000",
+			map[string]string{"Forwarded": `for="[2001:db8::1]:4711"`},
+			"2001:db8::1",
+		},
+		{
+			"X-Forwarded-For IPv6 in brackets with a port",
+			"xff",
+			"[::1]:5000",
+			map[string]string{"X-Forwarded-For": "[2001:db8::1]:4711"},
+			"2001:db8::1",
+		},
+		{
+			"bare IPv6",
+			"xff",
+			"[fd00::1]:5000",
+			map[string]string{"X-Forwarded-For": "2001:db8::1"},
+			"2001:db8::1",
+		},
+		{
+			"IPv4 with a port",
+			"xff",
+			"10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1:4711"},
+			"198.51.100.1",
+		},
+		{
+			"unknown stops the walk",
+			"forwarded",
+			"10.0.0.1:5000",
+			map[string]string{
+				"Forwarded": "for=198.51.100.1, for=unknown",
+			},
+			"10.0.0.1",
+		},
+		{
+			"obfuscated stops the walk",
+			"forwarded",
+			"10.0.0.1:5000",
+			map[string]string{
+				"Forwarded": "for=198.51.100.1, for=_hidden",
+			},
+			"10.0.0.1",
+		},
+		{
+			"element without for stops the walk",
+			"forwarded",
+			"10.0.0.1:5000",
+			map[string]string{"Forwarded": "for=198.51.100.1, proto=https"},
+			"10.0.0.1",
+		},
+		{
+			"X-Real-IP",
+			"x-real-ip",
+			"10.0.0.1:5000",
+			map[string]string{
+				"X-Real-IP":       "198.51.100.1",
+				"X-Forwarded-For": "192.0.2.66",
+			},
+			"198.51.100.1",
+		},
+		{
+			"X-Real-IP from an untrusted peer",
+			"x-real-ip",
+			"203.0.113.9:5000",
+			map[string]string{"X-Real-IP": "198.51.100.1"},
+			"203.0.113.9",
+		},
+	}
+
+	for _, c := range cases {
+		ProxyHeader = c.source
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		for k, v := range c.header {
+			r.Header.Set(k, v)
+		}
+		if ip := ResolveClientIP(r); ip == nil || ip.String() != c.expected {
+			t.Errorf("%s: expected %s, got %v", c.name, c.expected, ip)
+		}
+	}
+}
+
+func TestResolveClientIPHeaderLines(t *testing.T) {
+	saved := ProxyHeader
+	t.Cleanup(func() {
+		ProxyHeader = saved
+	})
+
+	// Separate header lines are one list, in order, so the last line
+	// is the nearest proxy's
+	for source, name := range map[string]string{
+		"xff":       "X-Forwarded-For",
+		"forwarded": "Forwarded",
+	} {
+		ProxyHeader = source
+		prefix := ""
+		if source == "forwarded" {
+			prefix = "for="
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Add(name, prefix+"192.0.2.66")
+		r.Header.Add(name, prefix+"198.51.100.1")
+		if ip := ResolveClientIP(r); ip.String() != "198.51.100.1" {
+			t.Errorf("%s: expected the last line's hop, got %v", name, ip)
+		}
+	}
+}
+
+func TestValidProxyHeader(t *testing.T) {
+	for _, v := range []string{"xff", "forwarded", "x-real-ip"} {
+		if !ValidProxyHeader(v) {
+			t.Errorf("Expected %s to be valid", v)
+		}
+	}
+	if ValidProxyHeader("X-Forwarded-For") {
+		t.Error("Expected header names to be rejected")
+	}
+}
@@ -30,6 +30,9 @@ import (
 
 const timeFormat string = "2006-01-02"
 
+// QuandlURL is the root of the Quandl API.
+var QuandlURL string = "https://www.quandl.com/api/v3"
+
 var client *http.Client = nil
 
 func init() {
@@ -39,6 +42,19 @@ func init() {
 type RequestResult struct {
 	OldValue, NewValue float64
 	OldTime, NewTime   time.Time
+
+	// Series is every value in the window, oldest first
+	Series []SeriesPoint
+}
+
+type SeriesPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// Gain is the fractional change in value, e.g. 0.5 for a 50% increase.
+func (r RequestResult) Gain() float64 {
+	return r.NewValue/r.OldValue - 1
 }
 
 func GetRequest(
@@ -60,28 +76,39 @@ func GetRequest(
 		return RequestResult{}, errorf("No column found")
 	}
 
-	uri := url.URL{
-		Scheme: "https",
-		Host:   "www.quandl.com",
-		Path: fmt.Sprintf(
-			"/api/v3/datasets/%s/%s/data.json",
-			dataset.Database,
-			dataset.Dataset,
+	calendar, ok := CalendarFor(dataset.Database)
+	if !ok {
+		return RequestResult{}, errorf("No trading calendar found")
+	}
+
+	uri, err := url.Parse(
+		fmt.Sprintf(
+			"%s/datasets/%s/%s/data.json",
+			QuandlURL,
+			url.PathEscape(dataset.Database),
+			url.PathEscape(dataset.Dataset),
 		),
+	)
+	if err != nil {
+		return RequestResult{}, errorf(err.Error())
 	}
 
-	t0 := t1.Add(-1 * time.Hour * 24 * 365)
+	// Compare the last full trading day before t1 against the trading
+	// day a year earlier, so weekends and holidays can't silently
+	// shift the window
+	newDate := calendar.OnOrBefore(t1.AddDate(0, 0, -1))
+	oldDate := calendar.OnOrBefore(newDate.AddDate(-1, 0, 0))
 
 	q := uri.Query()
 	q.Set("api_key", apiKey)
 	q.Set("column_index", strconv.Itoa(column))
-	q.Set("start_date", t0.Format(timeFormat))
-	q.Set("end_date", t1.Format(timeFormat))
+	q.Set("start_date", oldDate.Format(timeFormat))
+	q.Set("end_date", newDate.Format(timeFormat))
 	uri.RawQuery = q.Encode()
 
 	response, err := client.Get(uri.String())
 	if err != nil {
-		return RequestResult{}, nil
+		return RequestResult{}, errorf(err.Error())
 	}
 
 	defer response.Body.Close()
@@ -97,8 +124,12 @@ func GetRequest(
 	}{}
 	err = decoder.Decode(&result)
 
-	if err != nil {
-		return RequestResult{}, err
+	// Quandl explains its errors, including rate limiting, in JSON, but
+	// anything in front of it may not
+	if err != nil && response.StatusCode != http.StatusOK {
+		return RequestResult{}, errorf(response.Status)
+	} else if err != nil {
+		return RequestResult{}, errorf("Invalid response, " + err.Error())
 	} else if result.QuandlError.Code != "" {
 		err := errorf(
 			fmt.Sprintf(
@@ -116,8 +147,19 @@ func GetRequest(
 		return RequestResult{}, errorf("Insufficient data")
 	}
 
-	// Data comes ordered by date descending
+	// Data comes ordered by date descending, and must start and end on
+	// exactly the trading days we asked for
 	oldData, newData := allData[len(allData)-1], allData[0]
+	if !hasDate(oldData, oldDate) {
+		return RequestResult{}, errorf(
+			"No data for trading day " + oldDate.Format(timeFormat),
+		)
+	}
+	if !hasDate(newData, newDate) {
+		return RequestResult{}, errorf(
+			"No data for trading day " + newDate.Format(timeFormat),
+		)
+	}
 
 	extractData := func(in []interface{}) (t time.Time, v float64, err error) {
 		if len(in) != 2 {
@@ -158,10 +200,27 @@ func GetRequest(
 		return RequestResult{}, err
 	}
 
+	// Rows in between are only used for charting, so one with a
+	// missing value is skipped rather than failing the whole dataset
+	series := make([]SeriesPoint, 0, len(allData))
+	for i := len(allData) - 1; i >= 0; i-- {
+		t, v, err := extractData(allData[i])
+		if err == nil {
+			series = append(series, SeriesPoint{t, v})
+		}
+	}
+
 	return RequestResult{
 		oldValue, newValue,
 		oldTime, newTime,
+		series,
 	}, nil
+}
 
-	return RequestResult{}, nil
+func hasDate(row []interface{}, t time.Time) bool {
+	if len(row) == 0 {
+		return false
+	}
+	s, ok := row[0].(string)
+	return ok && s == t.Format(timeFormat)
 }
@@ -0,0 +1,257 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeQuandl stands in for the Quandl API.  It answers every request
+// with respond, and keeps the last request it got.
+type fakeQuandl struct {
+	*httptest.Server
+	respond func(w http.ResponseWriter, r *http.Request)
+	last    *http.Request
+}
+
+func newFakeQuandl(t *testing.T) *fakeQuandl {
+	fake := &fakeQuandl{}
+	fake.Server = httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				fake.last = r
+				fake.respond(w, r)
+			},
+		),
+	)
+
+	oldURL := QuandlURL
+	QuandlURL = fake.URL + "/api/v3"
+	t.Cleanup(func() {
+		QuandlURL = oldURL
+		fake.Close()
+	})
+	return fake
+}
+
+// quandlData answers with a row for each of the given dates and
+// values, newest first like Quandl does.
+func quandlData(rows ...[]interface{}) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(
+			map[string]interface{}{
+				"dataset_data": map[string]interface{}{"data": rows},
+			},
+		)
+	}
+}
+
+func quandlError(status int, code, message string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(
+			w,
+			`{"quandl_error": {"code": %q, "message": %q}}`,
+			code,
+			message,
+		)
+	}
+}
+
+var (
+	// A Wednesday, so the window runs from Tuesday the 6th back to
+	// Monday the 6th of June the year before
+	quandlFetch   = time.Date(2017, 6, 7, 2, 0, 0, 0, time.UTC)
+	quandlNewDate = "2017-06-06"
+	quandlOldDate = "2016-06-06"
+	quandlDataset = Dataset{"WIKI", "AAPL", "Apple Inc (AAPL)"}
+)
+
+func TestGetRequest(t *testing.T) {
+	fake := newFakeQuandl(t)
+	fake.respond = quandlData(
+		[]interface{}{quandlNewDate, 150.0},
+		[]interface{}{"2016-12-01", 110.0},
+		[]interface{}{"2016-09-01", nil},
+		[]interface{}{quandlOldDate, 100.0},
+	)
+
+	result, err := GetRequest("secret", quandlFetch, quandlDataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.last.URL.Path != "/api/v3/datasets/WIKI/AAPL/data.json" {
+		t.Errorf("Requested wrong path %s", fake.last.URL.Path)
+	}
+	query := fake.last.URL.Query()
+	for k, v := range map[string]string{
+		"api_key":      "secret",
+		"column_index": "4",
+		"start_date":   quandlOldDate,
+		"end_date":     quandlNewDate,
+	} {
+		if query.Get(k) != v {
+			t.Errorf("Expected %s=%s, got %q", k, v, query.Get(k))
+		}
+	}
+
+	if result.OldValue != 100 || result.NewValue != 150 {
+		t.Errorf(
+			"Expected values 100 and 150, got %v and %v",
+			result.OldValue,
+			result.NewValue,
+		)
+	}
+	if result.OldTime.Format(timeFormat) != quandlOldDate ||
+		result.NewTime.Format(timeFormat) != quandlNewDate {
+		t.Errorf("Wrong times %s and %s", result.OldTime, result.NewTime)
+	}
+	if gain := result.Gain(); gain != 0.5 {
+		t.Errorf("Expected a gain of 0.5, got %v", gain)
+	}
+
+	// The row with no value is left out of the chart, and the rest are
+	// oldest first
+	if len(result.Series) != 3 {
+		t.Fatalf("Expected 3 points, got %d", len(result.Series))
+	}
+	for i, v := range []float64{100, 110, 150} {
+		if result.Series[i].Value != v {
+			t.Errorf("Point %d is %v, expected %v", i, result.Series[i].Value, v)
+		}
+	}
+}
+
+func TestGetRequestErrors(t *testing.T) {
+	fake := newFakeQuandl(t)
+
+	cases := []struct {
+		name     string
+		dataset  Dataset
+		respond  func(http.ResponseWriter, *http.Request)
+		expected string
+	}{
+		{
+			"unknown database",
+			Dataset{"NOPE", "AAPL", ""},
+			quandlData(),
+			"No column found",
+		},
+		{
+			"quandl error",
+			quandlDataset,
+			quandlError(
+				http.StatusNotFound,
+				"QECx02",
+				"You have submitted an incorrect Quandl code.",
+			),
+			"Quandl error QECx02",
+		},
+		{
+			"rate limited",
+			quandlDataset,
+			quandlError(
+				http.StatusTooManyRequests,
+				"QELx01",
+				"You have exceeded the API speed limit.",
+			),
+			"Quandl error QELx01",
+		},
+		{
+			"rate limited by a proxy",
+			quandlDataset,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "10")
+				http.Error(w, "<h1>Slow down</h1>", http.StatusTooManyRequests)
+			},
+			"429 Too Many Requests",
+		},
+		{
+			"malformed json",
+			quandlDataset,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"dataset_data": {"data": [[`))
+			},
+			"Invalid response",
+		},
+		{
+			"too few rows",
+			quandlDataset,
+			quandlData([]interface{}{quandlNewDate, 150.0}),
+			"Insufficient data",
+		},
+		{
+			"window cut short",
+			quandlDataset,
+			quandlData(
+				[]interface{}{quandlNewDate, 150.0},
+				[]interface{}{"2016-12-01", 110.0},
+			),
+			"No data for trading day " + quandlOldDate,
+		},
+		{
+			"stale data",
+			quandlDataset,
+			quandlData(
+				[]interface{}{"2017-06-05", 150.0},
+				[]interface{}{quandlOldDate, 100.0},
+			),
+			"No data for trading day " + quandlNewDate,
+		},
+		{
+			"value not a number",
+			quandlDataset,
+			quandlData(
+				[]interface{}{quandlNewDate, "150"},
+				[]interface{}{quandlOldDate, 100.0},
+			),
+			"Value is not a float",
+		},
+	}
+
+	for _, c := range cases {
+		fake.respond = c.respond
+		_, err := GetRequest("secret", quandlFetch, c.dataset)
+		if err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		} else if !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%s: expected %q in error %q", c.name, c.expected, err)
+		}
+	}
+}
+
+func TestGetRequestUnreachable(t *testing.T) {
+	fake := newFakeQuandl(t)
+	fake.Close()
+
+	_, err := GetRequest("secret", quandlFetch, quandlDataset)
+	if err == nil {
+		t.Error("Expected an error from a server that isn't there")
+	}
+}
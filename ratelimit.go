@@ -0,0 +1,266 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitSweep is how many buckets a limiter tracks before it
+// first clears out the ones that have refilled.
+const rateLimitSweep = 10000
+
+// rateLimitMaxBuckets caps how many clients a limiter remembers.  A
+// sweep that can't get under it also forgets arbitrary clients, down
+// to three quarters of the cap.  They get a fresh burst, but a flood
+// of addresses can't use up all the memory.
+const rateLimitMaxBuckets = 100000
+
+// RateLimit lets each client make Burst requests at once, refilling
+// at Rate requests per second.  A zero Rate turns limiting off.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Validate rejects limits that would refuse every request.
+func (l RateLimit) Validate() error {
+	if l.Rate < 0 {
+		return fmt.Errorf("Rate %g is negative", l.Rate)
+	}
+	if l.Rate > 0 && l.Burst < 1 {
+		return fmt.Errorf("Burst %d is less than 1 with a rate set", l.Burst)
+	}
+	return nil
+}
+
+// RateLimitOverride changes some fields of a RateLimit, leaving the
+// unset ones as they were.
+type RateLimitOverride struct {
+	Rate  *float64
+	Burst *int
+}
+
+// MergeRateLimits applies overrides to RateLimits field by field.
+func MergeRateLimits(overrides map[string]RateLimitOverride) error {
+	for group, v := range overrides {
+		limit := RateLimits[group]
+		if v.Rate != nil {
+			limit.Rate = *v.Rate
+		}
+		if v.Burst != nil {
+			limit.Burst = *v.Burst
+		}
+		if err := limit.Validate(); err != nil {
+			return fmt.Errorf("Rate limit for %s: %s", group, err)
+		}
+		RateLimits[group] = limit
+	}
+	return nil
+}
+
+// RateLimits are the limits for each route group.
+var RateLimits map[string]RateLimit = map[string]RateLimit{
+	"pages":  {Rate: 2, Burst: 30},
+	"api":    {Rate: 1, Burst: 20},
+	"admin":  {Rate: 0.2, Burst: 10},
+	"static": {},
+}
+
+// RateLimitRoutes puts paths into route groups by prefix, longest
+// first.  Anything unmatched is in "pages".
+var RateLimitRoutes map[string]string = map[string]string{
+	"/api/":                 "api",
+	"/admin/":               "admin",
+	"/static/":              "static",
+	"/favicon.ico":          "static",
+	"/robots.txt":           "static",
+	"/manifest.webmanifest": "static",
+}
+
+// RateLimitAllowlist are networks that are never limited, like
+// monitoring systems.
+var RateLimitAllowlist []*net.IPNet = []*net.IPNet{}
+
+// RateLimitClock is what buckets refill by.
+var RateLimitClock Clock = SystemClock
+
+var rateLimiters map[string]*RateLimiter = map[string]*RateLimiter{}
+var rateLimitersLock sync.Mutex
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps a token bucket for each client address.
+type RateLimiter struct {
+	Limit RateLimit
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+
+	// nextSweep is how many buckets there can be before the next
+	// sweep
+	nextSweep int
+}
+
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		Limit:     limit,
+		buckets:   map[string]*tokenBucket{},
+		nextSweep: rateLimitSweep,
+	}
+}
+
+// Allow takes a token from client's bucket.  If there isn't one, it
+// returns false along with how long until there will be.
+func (l *RateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	if l.Limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= l.nextSweep {
+			l.sweep(now)
+		}
+		bucket = &tokenBucket{tokens: float64(l.Limit.Burst), last: now}
+		l.buckets[client] = bucket
+	}
+
+	elapsed := now.Sub(bucket.last).Seconds()
+	if elapsed > 0 {
+		bucket.tokens = math.Min(
+			float64(l.Limit.Burst),
+			bucket.tokens+elapsed*l.Limit.Rate,
+		)
+		bucket.last = now
+	}
+
+	if bucket.tokens < 1 {
+		wait := (1 - bucket.tokens) / l.Limit.Rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep forgets clients whose buckets would be full by now, since a
+// new bucket starts out full anyway.  The next sweep waits until the
+// survivors have doubled, so a flood of active clients costs one pass
+// per doubling rather than one per new client, up to the cap.
+func (l *RateLimiter) sweep(now time.Time) {
+	for client, bucket := range l.buckets {
+		refilled := bucket.tokens +
+			now.Sub(bucket.last).Seconds()*l.Limit.Rate
+		if refilled >= float64(l.Limit.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+
+	if len(l.buckets) >= rateLimitMaxBuckets {
+		for client := range l.buckets {
+			if len(l.buckets) <= rateLimitMaxBuckets*3/4 {
+				break
+			}
+			delete(l.buckets, client)
+		}
+	}
+
+	l.nextSweep = 2 * len(l.buckets)
+	if l.nextSweep < rateLimitSweep {
+		l.nextSweep = rateLimitSweep
+	} else if l.nextSweep > rateLimitMaxBuckets {
+		l.nextSweep = rateLimitMaxBuckets
+	}
+}
+
+func rateLimitGroup(path string) string {
+	group, match := "pages", ""
+	for prefix, v := range RateLimitRoutes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(match) {
+			group, match = v, prefix
+		}
+	}
+	return group
+}
+
+func rateLimiter(group string) *RateLimiter {
+	rateLimitersLock.Lock()
+	defer rateLimitersLock.Unlock()
+
+	limiter, ok := rateLimiters[group]
+	if !ok {
+		limiter = NewRateLimiter(RateLimits[group])
+		rateLimiters[group] = limiter
+	}
+	return limiter
+}
+
+// rateLimitKey is the bucket a client address goes in.  An IPv6
+// client usually has a whole /64 to pick addresses from, so that's
+// what gets limited.
+func rateLimitKey(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// rateLimited checks a request against its route group's limit,
+// writing a 429 and returning true if it's over.
+func rateLimited(w http.ResponseWriter, r *http.Request) bool {
+	client := ClientIP(r)
+	if ip := net.ParseIP(client); ip != nil {
+		for _, v := range RateLimitAllowlist {
+			if v.Contains(ip) {
+				return false
+			}
+		}
+		client = rateLimitKey(ip)
+	}
+
+	ok, wait := rateLimiter(rateLimitGroup(r.URL.Path)).Allow(
+		client,
+		RateLimitClock.Now(),
+	)
+	if ok {
+		return false
+	}
+
+	retry := int(math.Ceil(wait.Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return true
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMergeRateLimits(t *testing.T) {
+	old := RateLimits
+	t.Cleanup(func() {
+		RateLimits = old
+	})
+	RateLimits = map[string]RateLimit{
+		"pages":  {Rate: 2, Burst: 30},
+		"static": {},
+	}
+
+	rate, burst := 5.0, 0
+	err := MergeRateLimits(map[string]RateLimitOverride{
+		"pages": {Rate: &rate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit := RateLimits["pages"]; limit != (RateLimit{Rate: 5, Burst: 30}) {
+		t.Errorf("Expected the burst to be kept, got %+v", limit)
+	}
+
+	// Either of these would answer every request with a 429
+	for _, v := range []RateLimitOverride{
+		{Burst: &burst},
+		{Rate: &rate},
+	} {
+		group := "pages"
+		if v.Burst == nil {
+			group = "static"
+		}
+		err := MergeRateLimits(map[string]RateLimitOverride{group: v})
+		if err == nil {
+			t.Errorf("Expected %s to be rejected with %+v", group, RateLimits[group])
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{Rate: 1, Burst: 2})
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= rateLimitSweep; i++ {
+		if ok, _ := limiter.Allow(fmt.Sprint(i), now); !ok {
+			t.Fatalf("Client %d was limited", i)
+		}
+	}
+
+	// Nobody had refilled, so the sweep kept everyone and the next
+	// one waits for twice as many
+	if n := len(limiter.buckets); n != rateLimitSweep+1 {
+		t.Errorf("Expected %d buckets, got %d", rateLimitSweep+1, n)
+	}
+	if limiter.nextSweep != 2*rateLimitSweep {
+		t.Errorf("Next sweep at %d, expected %d", limiter.nextSweep, 2*rateLimitSweep)
+	}
+
+	// Once they've refilled, the next sweep clears them out
+	later := now.Add(time.Minute)
+	for i := rateLimitSweep + 1; i <= 2*rateLimitSweep; i++ {
+		limiter.Allow(fmt.Sprint(i), later)
+	}
+	if _, ok := limiter.buckets["0"]; ok {
+		t.Error("Expected refilled buckets to be swept")
+	}
+	if n := len(limiter.buckets); n > rateLimitSweep {
+		t.Errorf("Expected only the new clients, have %d", n)
+	}
+}
+
+func TestRateLimiterCap(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{Rate: 1, Burst: 2})
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// Nobody refills, so only the cap keeps the map in check
+	for i := 0; i < 2*rateLimitMaxBuckets; i++ {
+		limiter.Allow(fmt.Sprint(i), now)
+		if n := len(limiter.buckets); n > rateLimitMaxBuckets {
+			t.Fatalf("Tracking %d clients after %d", n, i+1)
+		}
+	}
+	if limiter.nextSweep > rateLimitMaxBuckets {
+		t.Errorf("Next sweep at %d, past the cap", limiter.nextSweep)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	oldLimits, oldLimiters := RateLimits, rateLimiters
+	oldAllowlist, oldClock := RateLimitAllowlist, RateLimitClock
+	t.Cleanup(func() {
+		RateLimits, rateLimiters = oldLimits, oldLimiters
+		RateLimitAllowlist, RateLimitClock = oldAllowlist, oldClock
+	})
+	RateLimits = map[string]RateLimit{
+		"pages":  {Rate: 0.5, Burst: 2},
+		"api":    {Rate: 1, Burst: 1},
+		"static": {},
+	}
+	rateLimiters = map[string]*RateLimiter{}
+	RateLimitAllowlist = mustParseCIDRs("198.51.100.0/24")
+	clock := newFakeClock(time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC))
+	RateLimitClock = clock
+
+	handler := Middleware(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		},
+	))
+	get := func(remote, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	client := "203.0.113.9:5000"
+	for i := 0; i < 2; i++ {
+		if w := get(client, "/"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d within the burst got %d", i, w.Code)
+		}
+	}
+	w := get(client, "/pick/2017-06-01")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected a 429 past the burst, got %d", w.Code)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "2" {
+		t.Errorf("Expected Retry-After 2 at half a request a second, got %q", retry)
+	}
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("429 is missing the security headers")
+	}
+
+	// Each group has its own bucket, and static files have none
+	if w := get(client, "/api/calculator"); w.Code != http.StatusOK {
+		t.Errorf("API request limited by the pages bucket: %d", w.Code)
+	}
+	if w := get(client, "/api/calculator"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the API burst of 1 to run out, got %d", w.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if w := get(client, "/static/style.css"); w.Code != http.StatusOK {
+			t.Fatalf("Static request limited: %d", w.Code)
+		}
+	}
+
+	// Other clients have their own buckets, and the allowlist has none
+	if w := get("203.0.113.10:5000", "/"); w.Code != http.StatusOK {
+		t.Errorf("Another client was limited: %d", w.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if w := get("198.51.100.7:5000", "/"); w.Code != http.StatusOK {
+			t.Fatalf("Allowlisted client limited: %d", w.Code)
+		}
+	}
+
+	// Waiting out the Retry-After is enough
+	clock.Sleep(2 * time.Second)
+	if w := get(client, "/"); w.Code != http.StatusOK {
+		t.Errorf("Still limited after Retry-After: %d", w.Code)
+	}
+
+	// IPv6 addresses in one /64 share a bucket
+	for i := 1; i <= 2; i++ {
+		get(fmt.Sprintf("[2001:db8:1:2::%d]:5000", i), "/")
+	}
+	if w := get("[2001:db8:1:2::3]:5000", "/"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the /64 to be limited, got %d", w.Code)
+	}
+	if w := get("[2001:db8:1:3::1]:5000", "/"); w.Code != http.StatusOK {
+		t.Errorf("Another /64 was limited: %d", w.Code)
+	}
+}
@@ -0,0 +1,337 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How far ahead to look for a matching day before giving up on a
+// schedule that can never fire (e.g. February 30th)
+const maxScheduleDays = 366 * 8
+
+var scheduleDescriptors map[string]string = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames map[string]int = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var weekdayNames map[string]int = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Schedule is a standard five field cron expression (minute, hour,
+// day of month, month, day of week) evaluated in a fixed time zone.
+type Schedule struct {
+	Spec     string
+	Location *time.Location
+
+	minutes, hours, days, months, weekdays uint64
+	daysStar, weekdaysStar                 bool
+}
+
+func ParseSchedule(spec string, location *time.Location) (*Schedule, error) {
+	expanded := strings.TrimSpace(spec)
+	if d, ok := scheduleDescriptors[expanded]; ok {
+		expanded = d
+	}
+
+	fields := strings.Fields(expanded)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf(
+			"Schedule %q should have 5 fields, has %d",
+			spec,
+			len(fields),
+		)
+	}
+
+	s := &Schedule{Spec: spec, Location: location}
+	var err error
+	if s.minutes, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hours, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.days, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.months, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	if s.weekdays, err = parseField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, err
+	}
+
+	// Both 0 and 7 mean Sunday
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	s.daysStar = strings.HasPrefix(fields[2], "*")
+	s.weekdaysStar = strings.HasPrefix(fields[4], "*")
+
+	return s, nil
+}
+
+// parseField turns one cron field into a bitmask of the values it
+// matches.  It accepts lists of values, ranges and steps, e.g.
+// "1-5", "*/15" or "mon,wed,fri".
+func parseField(
+	field string,
+	min, max int,
+	names map[string]int,
+) (uint64, error) {
+	errorf := func(message string) error {
+		return fmt.Errorf("Schedule field %q: %s", field, message)
+	}
+
+	parseValue := func(s string) (int, error) {
+		if v, ok := names[strings.ToLower(s)]; ok {
+			return v, nil
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, errorf(fmt.Sprintf("invalid value %q", s))
+		}
+		if v < min || v > max {
+			return 0, errorf(fmt.Sprintf("%d out of range", v))
+		}
+		return v, nil
+	}
+
+	var out uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, errorf("invalid step")
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = parseValue(bounds[0]); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = parseValue(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				high = max
+			}
+			if high < low {
+				return 0, errorf("range runs backwards")
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			out |= 1 << uint(v)
+		}
+	}
+
+	return out, nil
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	if s.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dayMatch := s.days&(1<<uint(t.Day())) != 0
+	weekdayMatch := s.weekdays&(1<<uint(t.Weekday())) != 0
+
+	// Like cron, if both day fields are restricted either may match
+	switch {
+	case s.daysStar && s.weekdaysStar:
+		return true
+	case s.daysStar:
+		return weekdayMatch
+	case s.weekdaysStar:
+		return dayMatch
+	default:
+		return dayMatch || weekdayMatch
+	}
+}
+
+// Next returns the first time strictly after t that the schedule
+// fires, or the zero time if it never does.  Each candidate is built
+// from wall clock fields in the schedule's zone, so runs stay at the
+// same local time across DST changes.  A time skipped by a DST jump
+// runs at the equivalent moment just after the jump instead.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.In(s.Location)
+	year, month, day := t.Date()
+
+	for i := 0; i < maxScheduleDays; i++ {
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, s.Location)
+		if !s.matchesDay(date) {
+			continue
+		}
+
+		var best time.Time
+		for h := 0; h < 24; h++ {
+			if s.hours&(1<<uint(h)) == 0 {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if s.minutes&(1<<uint(m)) == 0 {
+					continue
+				}
+
+				candidate := wallTime(date, h, m)
+				if candidate.After(t) &&
+					(best.IsZero() || candidate.Before(best)) {
+					best = candidate
+				}
+			}
+		}
+
+		if !best.IsZero() {
+			return best
+		}
+	}
+
+	return time.Time{}
+}
+
+// Previous returns the last time at or before t that the schedule
+// fired, or the zero time if it didn't within maxScheduleDays.
+func (s *Schedule) Previous(t time.Time) time.Time {
+	t = t.In(s.Location)
+	year, month, day := t.Date()
+
+	for i := 0; i < maxScheduleDays; i++ {
+		date := time.Date(year, month, day-i, 12, 0, 0, 0, s.Location)
+		if !s.matchesDay(date) {
+			continue
+		}
+
+		var best time.Time
+		for h := 0; h < 24; h++ {
+			if s.hours&(1<<uint(h)) == 0 {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if s.minutes&(1<<uint(m)) == 0 {
+					continue
+				}
+
+				candidate := wallTime(date, h, m)
+				if !candidate.After(t) && candidate.After(best) {
+					best = candidate
+				}
+			}
+		}
+
+		if !best.IsZero() {
+			return best
+		}
+	}
+
+	return time.Time{}
+}
+
+// wallTime returns h:m local time on the given date.  If that time
+// doesn't exist because of a DST jump, it returns the moment the same
+// distance past the jump instead.
+func wallTime(date time.Time, h, m int) time.Time {
+	year, month, day := date.Date()
+	out := time.Date(year, month, day, h, m, 0, 0, date.Location())
+
+	// time.Date may resolve a nonexistent time on either side of the
+	// jump, so compare wall clocks to see which way it went
+	want := time.Date(year, month, day, h, m, 0, 0, time.UTC)
+	got := time.Date(
+		out.Year(), out.Month(), out.Day(),
+		out.Hour(), out.Minute(), 0, 0,
+		time.UTC,
+	)
+	if got.Before(want) {
+		out = out.Add(want.Sub(got))
+	}
+	return out
+}
+
+// RefreshStatus tracks the scheduler's progress for the status page.
+type RefreshStatus struct {
+	lock    sync.RWMutex
+	nextRun time.Time
+}
+
+func (r *RefreshStatus) NextRun() time.Time {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.nextRun
+}
+
+func (r *RefreshStatus) setNextRun(t time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.nextRun = t
+}
+
+// RunSchedule calls f each time the schedule fires, until stop is
+// closed.  The next run is computed fresh after every call rather
+// than assuming a fixed interval, so a long-running f or a DST change
+// can't cause drift.
+func RunSchedule(
+	clock Clock,
+	schedule *Schedule,
+	status *RefreshStatus,
+	stop <-chan struct{},
+	f func(),
+) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		next := schedule.Next(clock.Now())
+		status.setNextRun(next)
+		if next.IsZero() {
+			return
+		}
+
+		clock.Sleep(next.Sub(clock.Now()))
+		f()
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{
+			"later today",
+			"0 2 * * *",
+			time.Date(2017, 6, 1, 1, 0, 0, 0, newYork),
+			time.Date(2017, 6, 1, 2, 0, 0, 0, newYork),
+		},
+		{
+			"strictly after",
+			"0 2 * * *",
+			time.Date(2017, 6, 1, 2, 0, 0, 0, newYork),
+			time.Date(2017, 6, 2, 2, 0, 0, 0, newYork),
+		},
+		{
+			"weekdays only",
+			"0 2 * * 1-5",
+			time.Date(2017, 6, 2, 3, 0, 0, 0, newYork),
+			time.Date(2017, 6, 5, 2, 0, 0, 0, newYork),
+		},
+		{
+			"skipped by spring forward",
+			"0 2 * * *",
+			time.Date(2017, 3, 11, 3, 0, 0, 0, newYork),
+			time.Date(2017, 3, 12, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			"day after spring forward",
+			"0 2 * * *",
+			time.Date(2017, 3, 12, 4, 0, 0, 0, newYork),
+			time.Date(2017, 3, 13, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			"first of a repeated hour",
+			"30 1 * * *",
+			time.Date(2017, 11, 4, 12, 0, 0, 0, newYork),
+			time.Date(2017, 11, 5, 5, 30, 0, 0, time.UTC),
+		},
+		{
+			"repeated hour only runs once",
+			"30 1 * * *",
+			time.Date(2017, 11, 5, 5, 30, 0, 0, time.UTC),
+			time.Date(2017, 11, 6, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			"other time zone",
+			"0 2 * * *",
+			time.Date(2017, 6, 1, 1, 0, 0, 0, time.UTC),
+			time.Date(2017, 6, 1, 6, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, c := range cases {
+		schedule, err := ParseSchedule(c.spec, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := schedule.Next(c.from); !next.Equal(c.expected) {
+			t.Errorf(
+				"%s: expected %s, got %s",
+				c.name,
+				c.expected.In(newYork),
+				next,
+			)
+		}
+	}
+}
+
+func TestSchedulePrevious(t *testing.T) {
+	schedule, err := ParseSchedule("0 2 * * 1-5", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		from     time.Time
+		expected time.Time
+	}{
+		{
+			time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC),
+			time.Date(2017, 6, 1, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			time.Date(2017, 6, 1, 2, 0, 0, 0, time.UTC),
+			time.Date(2017, 6, 1, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			time.Date(2017, 6, 1, 1, 0, 0, 0, time.UTC),
+			time.Date(2017, 5, 31, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			// Over the weekend, back to Friday
+			time.Date(2017, 6, 5, 1, 0, 0, 0, time.UTC),
+			time.Date(2017, 6, 2, 2, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, c := range cases {
+		if previous := schedule.Previous(c.from); !previous.Equal(c.expected) {
+			t.Errorf("From %s: expected %s, got %s", c.from, c.expected, previous)
+		}
+	}
+}
+
+func TestScheduleNever(t *testing.T) {
+	schedule, err := ParseSchedule("0 2 31 2 *", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := schedule.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Expected February 31st never to come, got %s", next)
+	}
+}
+
+func TestRunScheduleWeek(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := ParseSchedule("0 2 * * *", newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A week across the spring DST change, when 2:00 doesn't exist
+	clock := newFakeClock(time.Date(2017, 3, 9, 12, 0, 0, 0, newYork))
+	status := &RefreshStatus{}
+	stop := make(chan struct{})
+	runs := []time.Time{}
+	RunSchedule(clock, schedule, status, stop, func() {
+		runs = append(runs, clock.Now())
+		if len(runs) == 7 {
+			close(stop)
+		}
+	})
+
+	expected := []time.Time{
+		time.Date(2017, 3, 10, 2, 0, 0, 0, newYork),
+		time.Date(2017, 3, 11, 2, 0, 0, 0, newYork),
+		time.Date(2017, 3, 12, 3, 0, 0, 0, newYork),
+		time.Date(2017, 3, 13, 2, 0, 0, 0, newYork),
+		time.Date(2017, 3, 14, 2, 0, 0, 0, newYork),
+		time.Date(2017, 3, 15, 2, 0, 0, 0, newYork),
+		time.Date(2017, 3, 16, 2, 0, 0, 0, newYork),
+	}
+	if len(runs) != len(expected) {
+		t.Fatalf("Expected %d runs, got %d", len(expected), len(runs))
+	}
+	for i := range expected {
+		if !runs[i].Equal(expected[i]) {
+			t.Errorf("Run %d at %s, expected %s", i, runs[i], expected[i])
+		}
+	}
+	if next := status.NextRun(); !next.Equal(expected[6]) {
+		t.Errorf("Status has next run %s, expected %s", next, expected[6])
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// SecurityHeaders are set on every response that goes through
+// Middleware.  Nothing on the site uses inline scripts or styles or
+// needs framing, so the defaults lock all of that down.
+var SecurityHeaders map[string]string = map[string]string{
+	"Content-Security-Policy": "default-src 'self'; img-src 'self' data:; " +
+		"object-src 'none'; base-uri 'self'; form-action 'self'; " +
+		"frame-ancestors 'none'",
+	"X-Content-Type-Options": "nosniff",
+	"Referrer-Policy":        "strict-origin-when-cross-origin",
+	"X-Frame-Options":        "DENY",
+}
+
+// ServerConfig holds the limits for every listener, so a slow or
+// hostile client can't hold connections open forever.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+}
+
+func (c ServerConfig) Apply(server *http.Server) *http.Server {
+	server.ReadHeaderTimeout = c.ReadHeaderTimeout
+	server.ReadTimeout = c.ReadTimeout
+	server.WriteTimeout = c.WriteTimeout
+	server.IdleTimeout = c.IdleTimeout
+	server.MaxHeaderBytes = c.MaxHeaderBytes
+	return server
+}
+
+func (c ServerConfig) Server(addr string, handler http.Handler) *http.Server {
+	return c.Apply(&http.Server{Addr: addr, Handler: handler})
+}
+
+func setSecurityHeaders(w http.ResponseWriter) {
+	for k, v := range SecurityHeaders {
+		if v != "" {
+			w.Header().Set(k, v)
+		}
+	}
+}
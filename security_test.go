@@ -0,0 +1,110 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func securityHeadersFor(t *testing.T) http.Header {
+	handler := Middleware(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		},
+	))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	return w.Header()
+}
+
+func TestSecurityHeadersDefaults(t *testing.T) {
+	header := securityHeadersFor(t)
+	for _, k := range []string{
+		"Content-Security-Policy",
+		"X-Content-Type-Options",
+		"Referrer-Policy",
+		"X-Frame-Options",
+	} {
+		if got := header.Get(k); got == "" || got != SecurityHeaders[k] {
+			t.Errorf("Expected %s: %q, got %q", k, SecurityHeaders[k], got)
+		}
+	}
+}
+
+func TestSecurityHeadersDisabled(t *testing.T) {
+	saved := SecurityHeaders["X-Frame-Options"]
+	t.Cleanup(func() {
+		SecurityHeaders["X-Frame-Options"] = saved
+	})
+
+	// As main does for a config value set to nothing
+	SecurityHeaders["X-Frame-Options"] = ""
+
+	header := securityHeadersFor(t)
+	if _, ok := header["X-Frame-Options"]; ok {
+		t.Errorf("Disabled header sent as %q", header.Get("X-Frame-Options"))
+	}
+	if header.Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("Disabling one header dropped the others")
+	}
+}
+
+func TestServerConfigApply(t *testing.T) {
+	config := ServerConfig{
+		ReadHeaderTimeout: 1 * time.Second,
+		ReadTimeout:       2 * time.Second,
+		WriteTimeout:      3 * time.Second,
+		IdleTimeout:       4 * time.Second,
+		MaxHeaderBytes:    5000,
+	}
+	handler := http.NotFoundHandler()
+	server := config.Server(":8080", handler)
+
+	if server.Addr != ":8080" || server.Handler == nil {
+		t.Errorf("Lost the address or handler: %q, %v", server.Addr, server.Handler)
+	}
+	if server.ReadHeaderTimeout != time.Second ||
+		server.ReadTimeout != 2*time.Second ||
+		server.WriteTimeout != 3*time.Second ||
+		server.IdleTimeout != 4*time.Second {
+		t.Errorf(
+			"Wrong timeouts %s, %s, %s, %s",
+			server.ReadHeaderTimeout,
+			server.ReadTimeout,
+			server.WriteTimeout,
+			server.IdleTimeout,
+		)
+	}
+	if server.MaxHeaderBytes != 5000 {
+		t.Errorf("Expected MaxHeaderBytes 5000, got %d", server.MaxHeaderBytes)
+	}
+
+	// Apply changes a server made elsewhere, like the TLS one
+	existing := &http.Server{Addr: ":443"}
+	if config.Apply(existing) != existing || existing.WriteTimeout != 3*time.Second {
+		t.Error("Apply didn't set the limits on the server it was given")
+	}
+}
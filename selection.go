@@ -0,0 +1,49 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"sync/atomic"
+)
+
+// SelectionStore holds the current selection.  Each one is stored as
+// an immutable snapshot and replaced whole, so readers never wait on
+// the refresh or see a half written pick.  Nothing may modify a
+// snapshot, or the Series it shares with other copies, once it's
+// stored.
+type SelectionStore struct {
+	current atomic.Value
+}
+
+func NewSelectionStore() *SelectionStore {
+	s := &SelectionStore{}
+	s.current.Store(&DailySelection{})
+	return s
+}
+
+// Load returns the current selection, which is the zero selection
+// until one is stored.
+func (s *SelectionStore) Load() DailySelection {
+	return *s.current.Load().(*DailySelection)
+}
+
+func (s *SelectionStore) Store(selection DailySelection) {
+	s.current.Store(&selection)
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// These tests are meant to be run with -race, which is what catches
+// handlers reading the selection while the refresh replaces it.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testSelection(i int) DailySelection {
+	base := time.Date(2017, 6, 1, 2, 0, 0, 0, time.UTC)
+	old := float64(i + 1)
+	return DailySelection{
+		Dataset: Dataset{
+			Database:    "TEST",
+			Dataset:     "SET",
+			Description: "Test dataset",
+		},
+		RequestResult: RequestResult{
+			OldValue: old,
+			NewValue: 2 * old,
+			OldTime:  base.AddDate(-1, 0, i),
+			NewTime:  base.AddDate(0, 0, i),
+			Series: []SeriesPoint{
+				{Time: base.AddDate(-1, 0, i), Value: old},
+				{Time: base.AddDate(0, 0, i), Value: 2 * old},
+			},
+		},
+		Time: base.AddDate(0, 0, i),
+	}
+}
+
+func TestSelectionStoreStartsEmpty(t *testing.T) {
+	if s := NewSelectionStore().Load(); !s.Time.IsZero() {
+		t.Errorf("New store has selection from %s", s.Time)
+	}
+}
+
+func TestSelectionStoreConcurrent(t *testing.T) {
+	store := NewSelectionStore()
+	store.Store(testSelection(0))
+
+	wait := sync.WaitGroup{}
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for i := 1; i <= 1000; i++ {
+			store.Store(testSelection(i))
+		}
+	}()
+
+	// Every snapshot has to be one whole selection, never fields from
+	// two different ones
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 1000; j++ {
+				s := store.Load()
+				if s.NewValue != 2*s.OldValue || !s.NewTime.Equal(s.Time) {
+					t.Errorf("Torn selection: %+v", s.RequestResult)
+					return
+				}
+			}
+		}()
+	}
+	wait.Wait()
+
+	if s := store.Load(); s.OldValue != 1001 {
+		t.Errorf("Expected the last selection stored, got %v", s.OldValue)
+	}
+}
+
+func TestHandlersDuringRefresh(t *testing.T) {
+	config := testConfig(t)
+	store := NewSelectionStore()
+	store.Store(testSelection(0))
+	handlers := map[string]http.Handler{
+		"/":                  IndexHandler(config, store),
+		"/status":            StatusHandler(config, store, &RefreshStatus{}),
+		"/og.png":            CardHandler(config, store),
+		"/calculator":        CalculatorHandler(config, store),
+		"/api/v1/calculator": CalculatorAPIHandler(config, store),
+	}
+
+	wait := sync.WaitGroup{}
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for i := 1; i <= 10; i++ {
+			StoreSelection(config, testSelection(i), store)
+		}
+	}()
+
+	for path, handler := range handlers {
+		wait.Add(1)
+		go func(path string, handler http.Handler) {
+			defer wait.Done()
+			for i := 0; i < 10; i++ {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+				if w.Code != http.StatusOK {
+					t.Errorf("%s returned %d: %s", path, w.Code, w.Body)
+					return
+				}
+			}
+		}(path, handler)
+	}
+	wait.Wait()
+}
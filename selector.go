@@ -22,27 +22,133 @@ package main
 import (
 	"log"
 	"math/rand"
-	"os"
 	"sort"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const maxQueuedSelections = 1024
-const selectTopN = 20
 
 type selectionList []DailySelection
 
+var selectionRunning int32
+
+// selectionRand is what policies draw from for live selections.  Only
+// one selection runs at a time, so it's never used concurrently.
+var selectionRand *rand.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// SelectionInProgress reports whether a selection is being made, by
+// SelectSynchronously, a replay or a pin for today.
+func SelectionInProgress() bool {
+	return atomic.LoadInt32(&selectionRunning) != 0
+}
+
+// beginSelection claims the right to make a selection, so only one
+// runs at a time.  Whoever gets it must call endSelection.
+func beginSelection() bool {
+	return atomic.CompareAndSwapInt32(&selectionRunning, 0, 1)
+}
+
+func endSelection() {
+	atomic.StoreInt32(&selectionRunning, 0)
+}
+
 func SelectSynchronously(
 	config Config,
-	selection *DailySelection,
-	selectionLock *sync.RWMutex,
+	selection *SelectionStore,
+	admin *AdminState,
+	candidates *CandidateList,
 ) {
+	if !beginSelection() {
+		log.Println("Selection process already running, skipping")
+		return
+	}
+	defer endSelection()
+
 	log.Println("Beginning selection process")
 
+	// Overrides may have been made through another replica
+	admin.Reload()
+	fetchTime, results := fetchSelections(config)
+	pick, ok := choosePick(
+		config,
+		admin,
+		LoadHistory(config.Cache, config.HistoryFile),
+		results,
+		fetchTime,
+		selectionRand,
+	)
+
+	// Only the pick needs its full series, so don't archive thousands
+	// of them with the candidates
+	for i := range results {
+		results[i].Series = nil
+	}
+
+	// A run where every fetch failed keeps the last leaderboard
+	// rather than wiping it
+	if len(results) > 0 {
+		if err := candidates.Store(config, fetchTime, results); err != nil {
+			log.Println("Error writing candidate list:", err)
+		}
+		err := SaveCandidates(config.Cache, config.TempFile, fetchTime, results)
+		if err != nil {
+			log.Println("Error caching candidate list:", err)
+		}
+	}
+
+	if !ok {
+		log.Println("No candidates to select from, keeping previous selection")
+		return
+	}
+
+	StoreSelection(config, pick, selection)
+	log.Println("Completed selection process")
+}
+
+// ReplaySelection works out what the pick would have been had the
+// selection run on date, from the history as it was then.  Nothing is
+// stored or sent.  The policy's draw is seeded from the run time, so
+// replaying a date always gives the same answer for the same data.
+// It still fetches every dataset within Limits, so it takes as long
+// as a real selection.
+func ReplaySelection(
+	config Config,
+	admin *AdminState,
+	date time.Time,
+) (DailySelection, bool) {
+	if !beginSelection() {
+		log.Println("Selection process already running, skipping replay")
+		return DailySelection{}, false
+	}
+	defer endSelection()
+
+	year, month, day := date.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, config.Schedule.Location)
+	at := config.Schedule.Next(midnight.Add(-time.Nanosecond))
+	if at.IsZero() {
+		return DailySelection{}, false
+	}
+
+	log.Println("Replaying selection process for", at)
+	config.Clock = ShiftClock(config.Clock, at)
+	fetchTime, results := fetchSelections(config)
+	return choosePick(
+		config,
+		admin,
+		LoadHistory(config.Cache, config.HistoryFile).Before(fetchTime),
+		results,
+		fetchTime,
+		rand.New(rand.NewSource(at.Unix())),
+	)
+}
+
+// fetchSelections gets every dataset's results as of now on the
+// config's clock, best first.
+func fetchSelections(config Config) (time.Time, []DailySelection) {
 	results := []DailySelection{}
-	fetchTime := time.Now()
-	RunLimited(func(set Dataset) {
+	fetchTime := config.Clock.Now()
+	RunLimited(config.Clock, func(set Dataset) {
 		result, err := GetRequest(config.APIKey, fetchTime, set)
 		if err != nil {
 			log.Println(err)
@@ -53,23 +159,84 @@ func SelectSynchronously(
 	})
 
 	sort.Sort(sort.Reverse(selectionList(results)))
+	return fetchTime, results
+}
+
+// choosePick picks from results: the pinned dataset if there is one,
+// otherwise by policy from those not blacklisted or recently picked.
+func choosePick(
+	config Config,
+	admin *AdminState,
+	history SelectionHistory,
+	results []DailySelection,
+	t time.Time,
+	random *rand.Rand,
+) (DailySelection, bool) {
+	if pick, ok := pinnedSelection(config, admin, results, t); ok {
+		return pick, true
+	}
+
+	allowed := admin.FilterBlacklisted(results)
+	eligible := history.Eligible(allowed, t, config.NoRepeatDays)
+	return config.Policy.Pick(eligible, random)
+}
+
+// pinnedSelection returns the dataset an operator pinned for the day
+// of t, fetching it directly if it didn't make it into the results.
+func pinnedSelection(
+	config Config,
+	admin *AdminState,
+	results []DailySelection,
+	t time.Time,
+) (DailySelection, bool) {
+	pinned, ok := admin.PinFor(t.In(config.Schedule.Location))
+	if !ok {
+		return DailySelection{}, false
+	}
 
-	selectionLock.Lock()
-	*selection = results[rand.Intn(selectTopN)]
-	selectionLock.Unlock()
+	for _, v := range results {
+		if v.Dataset.Key() == pinned.Key() {
+			return v, true
+		}
+	}
 
-	cacheFout, err := os.Create(config.TempFile)
-	defer cacheFout.Close()
+	result, err := GetRequest(config.APIKey, t, pinned)
 	if err != nil {
-		log.Println("Error opening selection cache file")
+		log.Println("Error fetching pinned dataset:", err)
+		return DailySelection{}, false
 	}
+	return DailySelection{pinned, result, t}, true
+}
+
+// StoreSelection makes pick the current selection, records it in the
+// history and the cache file, and sends out notifications.  The
+// notifications go last, so a receiver that follows the link straight
+// away finds the pick in the history and the feeds.
+func StoreSelection(
+	config Config,
+	pick DailySelection,
+	selection *SelectionStore,
+) {
+	selection.Store(pick)
 
-	err = WriteBackup(cacheFout, *selection)
+	// Only the chart needs the series, so it goes in its own blob
+	// rather than growing the history with every pick
+	date := pick.Time.In(config.Schedule.Location).Format(timeFormat)
+	err := SaveSeries(config.Cache, config.TempFile, date, pick.Series)
 	if err != nil {
-		log.Println("Error writing selection cache")
+		log.Println("Error writing selection series:", err)
+	}
+	entry := pick
+	entry.Series = nil
+	if err := AppendHistory(config.Cache, config.HistoryFile, entry); err != nil {
+		log.Println("Error writing selection history:", err)
 	}
 
-	log.Println("Completed selection process")
+	if err := SaveBackup(config.Cache, config.TempFile, pick); err != nil {
+		log.Println("Error writing selection cache:", err)
+	}
+
+	config.Notifications.Send(pick)
 }
 
 func (l selectionList) Len() int {
@@ -77,7 +244,7 @@ func (l selectionList) Len() int {
 }
 
 func (l selectionList) Less(i, j int) bool {
-	return l[i].NewValue/l[i].OldValue < l[j].NewValue/l[j].OldValue
+	return l[i].Gain() < l[j].Gain()
 }
 
 func (l selectionList) Swap(i, j int) {
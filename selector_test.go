@@ -0,0 +1,267 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selectorTest sets up a config that always picks the best eligible
+// dataset from a handful, served by a fake Quandl where the later
+// datasets gain more.
+func selectorTest(t *testing.T, clock Clock) Config {
+	oldDatasets, oldLimits := Datasets, Limits
+	t.Cleanup(func() {
+		Datasets, Limits = oldDatasets, oldLimits
+	})
+
+	Datasets = []Dataset{
+		{"WIKI", "A", "Dataset A"},
+		{"WIKI", "B", "Dataset B"},
+		{"WIKI", "C", "Dataset C"},
+		{"WIKI", "D", "Dataset D"},
+		{"WIKI", "E", "Dataset E"},
+	}
+	Limits = map[time.Duration]int{10 * time.Second: 2}
+
+	fake := newFakeQuandl(t)
+	fake.respond = func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Split(r.URL.Path, "/")[5]
+		gain := float64(name[0]-'A'+1) * 10
+		query := r.URL.Query()
+		quandlData(
+			[]interface{}{query.Get("end_date"), 100 + gain},
+			[]interface{}{query.Get("start_date"), 100.0},
+		)(w, r)
+	}
+
+	config := testConfig(t)
+	config.NoRepeatDays = 30
+	config.Policy = UniformPolicy{1}
+	config.Clock = clock
+	return config
+}
+
+func TestSimulatedWeek(t *testing.T) {
+	start := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	config := selectorTest(t, clock)
+	dir := config.Cache.(LocalStorage).Dir
+	admin := LoadAdminState(filepath.Join(dir, "admin"))
+	candidates := LoadCandidateList(filepath.Join(dir, "candidates"))
+	selection := NewSelectionStore()
+
+	stop := make(chan struct{})
+	days := 0
+	RunSchedule(clock, config.Schedule, &RefreshStatus{}, stop, func() {
+		SelectSynchronously(config, selection, admin, candidates)
+		if days++; days == 7 {
+			close(stop)
+		}
+	})
+
+	// Each dataset once from best to worst, then the window shrinks
+	// and the ones picked longest ago come back
+	expected := []string{"E", "D", "C", "B", "A", "E", "D"}
+	history := LoadHistory(config.Cache, config.HistoryFile)
+	if len(history) != len(expected) {
+		t.Fatalf("Expected %d picks, got %d", len(expected), len(history))
+	}
+	for i, v := range history {
+		if v.Dataset.Dataset != expected[i] {
+			t.Errorf("Day %d picked %s, expected %s", i, v.Dataset.Dataset, expected[i])
+		}
+		run := time.Date(2017, 6, 2+i, 2, 0, 0, 0, time.UTC)
+		if !v.Time.Equal(run) {
+			t.Errorf("Day %d picked at %s, expected %s", i, v.Time, run)
+		}
+
+		// The history stays small, with each chart series stored
+		// on its own
+		if len(v.Series) != 0 {
+			t.Errorf("Day %d has its series in the history", i)
+		}
+		series, err := LoadSeries(
+			config.Cache,
+			config.TempFile,
+			run.Format(timeFormat),
+		)
+		if err != nil {
+			t.Errorf("Day %d has no series: %s", i, err)
+		} else if len(series) != 2 {
+			t.Errorf("Day %d has %d points, expected 2", i, len(series))
+		}
+	}
+
+	if current := selection.Load(); current.Dataset.Dataset != "D" {
+		t.Errorf("Expected D to be current, got %s", current.Dataset.Dataset)
+	}
+	cached, err := LoadBackup(config.Cache, config.TempFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached.Time.Equal(history[len(history)-1].Time) {
+		t.Errorf("Cache has the pick from %s", cached.Time)
+	}
+
+	// Five datasets at two per ten seconds means two waits per run
+	elapsed := clock.Now().Sub(history[len(history)-1].Time)
+	if elapsed != 22*time.Second {
+		t.Errorf("Last run took %s, expected 22s", elapsed)
+	}
+}
+
+func TestFailedRunKeepsCandidates(t *testing.T) {
+	clock := newFakeClock(time.Date(2017, 6, 1, 2, 0, 0, 0, time.UTC))
+	config := selectorTest(t, clock)
+	dir := config.Cache.(LocalStorage).Dir
+	admin := LoadAdminState(filepath.Join(dir, "admin"))
+	candidates := LoadCandidateList(filepath.Join(dir, "candidates"))
+	selection := NewSelectionStore()
+
+	SelectSynchronously(config, selection, admin, candidates)
+	before, list := candidates.Get()
+	if len(list) != len(Datasets) {
+		t.Fatalf("Expected %d candidates, got %d", len(Datasets), len(list))
+	}
+
+	// Every fetch fails the next day
+	QuandlURL = "http://127.0.0.1:1/api/v3"
+	clock.Sleep(24 * time.Hour)
+	SelectSynchronously(config, selection, admin, candidates)
+
+	after, list := candidates.Get()
+	if !after.Equal(before) || len(list) != len(Datasets) {
+		t.Errorf("Failed run left %d candidates from %s", len(list), after)
+	}
+	if _, _, err := candidates.Archived("2017-06-02"); err == nil {
+		t.Error("Failed run archived a candidate list")
+	}
+	cached, _, err := LoadCandidates(config.Cache, config.TempFile)
+	if err != nil || !cached.Equal(before) {
+		t.Errorf("Failed run replaced the cached candidates: %s %v", cached, err)
+	}
+}
+
+// storedNotifier checks what a receiver would find when told about a
+// pick.
+type storedNotifier struct {
+	config  Config
+	history SelectionHistory
+	backup  DailySelection
+}
+
+func (n *storedNotifier) Name() string {
+	return "stored"
+}
+
+func (n *storedNotifier) Notify(selection DailySelection) error {
+	n.history = LoadHistory(n.config.Cache, n.config.HistoryFile)
+	n.backup, _ = LoadBackup(n.config.Cache, n.config.TempFile)
+	return nil
+}
+
+func TestStoreSelectionNotifiesLast(t *testing.T) {
+	config := testConfig(t)
+	notifier := &storedNotifier{config: config}
+	config.Notifications = &Notifications{Notifiers: []Notifier{notifier}}
+
+	pick := testSelection(0)
+	StoreSelection(config, pick, NewSelectionStore())
+	config.Notifications.Wait()
+
+	if len(notifier.history) != 1 || !notifier.history[0].Time.Equal(pick.Time) {
+		t.Errorf("Notified before the history was written: %v", notifier.history)
+	}
+	if !notifier.backup.Time.Equal(pick.Time) {
+		t.Error("Notified before the cache was written")
+	}
+}
+
+func TestReplaySelection(t *testing.T) {
+	clock := newFakeClock(time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC))
+	config := selectorTest(t, clock)
+	admin := LoadAdminState(filepath.Join(t.TempDir(), "admin"))
+
+	// E was picked the day before, so it's out, but D being picked
+	// later mustn't count
+	history := SelectionHistory{
+		{
+			Dataset: Datasets[4],
+			Time:    time.Date(2017, 6, 9, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			Dataset: Datasets[3],
+			Time:    time.Date(2017, 6, 11, 2, 0, 0, 0, time.UTC),
+		},
+	}
+	if err := SaveHistory(config.Cache, config.HistoryFile, history); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2017, 6, 10, 0, 0, 0, 0, time.UTC)
+	pick, ok := ReplaySelection(config, admin, date)
+	if !ok {
+		t.Fatal("Replay made no pick")
+	}
+	if pick.Dataset.Dataset != "D" {
+		t.Errorf("Expected D, got %s", pick.Dataset.Dataset)
+	}
+	if run := date.Add(2 * time.Hour); !pick.Time.Equal(run) {
+		t.Errorf("Expected a pick at %s, got %s", run, pick.Time)
+	}
+	if pick.NewTime.Format(timeFormat) != "2017-06-09" {
+		t.Errorf("Expected data up to 2017-06-09, got %s", pick.NewTime)
+	}
+
+	if after := LoadHistory(config.Cache, config.HistoryFile); len(after) != len(history) {
+		t.Errorf("Replay changed the history to %d picks", len(after))
+	}
+}
+
+func TestReplaySelectionDeterministic(t *testing.T) {
+	clock := newFakeClock(time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC))
+	config := selectorTest(t, clock)
+	config.Policy = UniformPolicy{}
+	admin := LoadAdminState(filepath.Join(t.TempDir(), "admin"))
+
+	// With all five equally likely, a fresh draw each time would
+	// rarely agree four times over
+	date := time.Date(2017, 6, 10, 0, 0, 0, 0, time.UTC)
+	first, ok := ReplaySelection(config, admin, date)
+	if !ok {
+		t.Fatal("Replay made no pick")
+	}
+	for i := 0; i < 4; i++ {
+		pick, _ := ReplaySelection(config, admin, date)
+		if pick.Dataset != first.Dataset {
+			t.Errorf(
+				"Replay %d picked %s, the first picked %s",
+				i+2,
+				pick.Dataset.Dataset,
+				first.Dataset.Dataset,
+			)
+		}
+	}
+}
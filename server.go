@@ -20,142 +20,158 @@
 package main
 
 import (
-	"errors"
-	"fmt"
-	"github.com/sebest/xff"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
-	"sync"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-var indexTemplate *template.Template
-
-func init() {
-	var err error
-	indexTemplate, err = template.New("index").Parse(
-		`<!DOCTYPE HTML>
-<html>
-	<head>
-		<title>Daily Hindsight</title>
-		<style>
-		body {
-			font-size: 25px;
-		}
-
-		div.container {
-			width: 50%;
-			margin-left: auto;
-			margin-right: auto;
-		}
-
-		h1 {
-			text-align: center;
-		}
-
-		p.top {
-			text-align: center;
-		}
-		</style>
-	</head>
-
-	<body>
-		<div class="container">
-			<h1>Daily Hindsight</h1>
-			<p class="top">
-				Today's Hindsight Investment: <strong>{{.description}}</strong>
-			</p>
-			<p class="top">
-				Between {{.old_time}} and {{.new_time}},
-				<strong>{{.symbol}}</strong> increased in value by
-				<strong>{{.percent_increase}}</strong>%.
-			</p>
-			<h2>Why?</h2>
-			<p>
-				The purpose of this page is to demonstrate hind-sight
-				bias.  It's easy to blame ourselves for not
-				having taken advantage of an opportunity that seems
-				obvious in retrospect, but the reality is that every
-				day we miss countless investments which, unbeknownst
-				to anyone at the time, are destined to increase in
-				value drastically.  Every day this page will display
-				one of the higher-performing securities from a year
-				ago, hopefully illustrating the fact that most people
-				had no idea what would come next.
-			</p>
-			<p>
-				You can find the source code for this project at
-				<a href="https://www.github.com/bieber/dailyhindsight/">
-					github.com/bieber/dailyhindsight
-				</a>.  If you find erroneous data displayed here,
-				please let me know by writing to
-				<a href="mailto:baddata@biebersprojects.com">
-					baddata@biebersprojects.com
-				</a>.
-			</p>
-		</div>
-	</body>
-</html>
-`,
-	)
-
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
 func Middleware(in http.Handler) http.Handler {
-	logged := http.HandlerFunc(
+	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Println("PANIC:", err)
+				}
+			}()
+
+			r = withClientIP(r)
 			log.Printf(
 				"[%s] %s %s",
 				r.Method,
-				r.RemoteAddr,
+				ClientIP(r),
 				r.URL.String(),
 			)
+
+			setSecurityHeaders(w)
+			if rateLimited(w, r) {
+				return
+			}
 			in.ServeHTTP(w, r)
 		},
 	)
+}
 
-	xffm, err := xff.Default()
-	if err != nil {
-		panic(err)
-	}
-	forwarded := xffm.Handler(logged)
+func IndexHandler(
+	config Config,
+	selection *SelectionStore,
+) http.Handler {
+	charts := &ChartCache{}
+	pages := &PageCache{}
 
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			defer func() {
-				if err := recover(); err != nil {
-					log.Println("PANIC:", err)
-				}
-			}()
+			locale := NegotiateLocale(w, r)
+			current := selection.Load()
+
+			page := pages.For(
+				SelectionKey(current)+"|"+locale.Tag,
+				current.Time,
+				func(out io.Writer) error {
+					data := SelectionData(
+						config,
+						locale,
+						current,
+						charts.For(locale, current),
+					)
+					// The Host header mustn't go into a page kept
+					// for everyone, so only the configured URL does
+					ShareData(
+						config,
+						strings.TrimSuffix(config.Site.URL, "/"),
+						locale,
+						current,
+						data,
+					)
+					return config.Templates.Render(out, locale, "index", data)
+				},
+			)
 
-			forwarded.ServeHTTP(w, r)
+			// Until the latest scheduled run has stored its pick,
+			// whether here or on the leader, the page is about to
+			// change
+			now := config.Clock.Now()
+			expires := config.Schedule.Next(now)
+			if SelectionInProgress() ||
+				current.Time.Before(config.Schedule.Previous(now)) {
+				expires = time.Time{}
+			}
+			ServePage(w, r, page, now, expires)
 		},
 	)
 }
 
-func IndexHandler(
+// SelectionData is the index template's data for a pick.
+func SelectionData(
 	config Config,
-	selection *DailySelection,
-	selectionLock *sync.RWMutex,
+	locale *Locale,
+	selection DailySelection,
+	chart template.HTML,
+) map[string]interface{} {
+	rawIncrease := selection.NewValue - selection.OldValue
+	percentIncrease := 100 * rawIncrease / selection.OldValue
+	date := selection.Time.In(config.Schedule.Location).Format(timeFormat)
+
+	data := map[string]interface{}{
+		"symbol":            selection.Dataset.Dataset,
+		"description":       selection.Dataset.Description,
+		"percent_increase":  locale.Percent(percentIncrease, 0),
+		"old_time":          locale.Date(selection.OldTime),
+		"new_time":          locale.Date(selection.NewTime),
+		"chart":             chart,
+		"calculator_amount": locale.Number(config.Calculator.Amount, 0),
+		"permalink":         PermalinkPath(date),
+	}
+	if calendar, ok := CalendarFor(selection.Dataset.Database); ok {
+		data["calendar"] = calendar.Name
+		data["trading_days"] = locale.Number(
+			float64(calendar.TradingDaysBetween(
+				selection.OldTime,
+				selection.NewTime,
+			)),
+			0,
+		)
+	}
+	return data
+}
+
+func StatusHandler(
+	config Config,
+	selection *SelectionStore,
+	status *RefreshStatus,
 ) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			rawIncrease := selection.NewValue - selection.OldValue
-			percentIncrease := 100 * rawIncrease / selection.OldValue
-			timeFormat := "January 2, 2006"
+			locale := NegotiateLocale(w, r)
+			location := config.Schedule.Location
 
-			data := map[string]string{
-				"symbol":           selection.Dataset.Dataset,
-				"description":      selection.Dataset.Description,
-				"percent_increase": fmt.Sprintf("%.0f", percentIncrease),
-				"old_time":         selection.OldTime.Format(timeFormat),
-				"new_time":         selection.NewTime.Format(timeFormat),
+			current := selection.Load()
+			description := current.Dataset.Description
+			selectionTime := current.Time
+
+			nextRun := ""
+			if next := status.NextRun(); !next.IsZero() {
+				nextRun = locale.DateTime(next.In(location))
+			}
+
+			data := map[string]interface{}{
+				"description":    description,
+				"selection_time": locale.DateTime(selectionTime.In(location)),
+				"schedule":       config.Schedule.Spec,
+				"timezone":       location.String(),
+				"next_run":       nextRun,
+			}
+			if SelectionInProgress() {
+				data["in_progress"] = "yes"
 			}
 
-			err := indexTemplate.ExecuteTemplate(w, "index", data)
+			err := config.Templates.Render(w, locale, "status", data)
 			if err != nil {
 				panic(err)
 			}
@@ -163,20 +179,272 @@ func IndexHandler(
 	)
 }
 
-func FaviconHandler() http.Handler {
+func CandidatesHandler(candidates *CandidateList) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query, err := ParseCandidateQuery(r.URL.Query().Get)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			t, list, err := query.Run(candidates)
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			writeJSON(w, candidateResponse(t, list))
+		},
+	)
+}
+
+func LeaderboardHandler(
+	config Config,
+	candidates *CandidateList,
+) http.Handler {
+	type option struct {
+		Name     string
+		Selected bool
+	}
+	type column struct {
+		Title, Href        string
+		Active, Descending bool
+	}
+	type row struct {
+		Rank                            int
+		Symbol, Description, Database   string
+		OldTime, NewTime, PercentChange string
+	}
+
+	columns := []struct{ title, sort string }{
+		{"column.rank", "rank"},
+		{"column.symbol", "symbol"},
+		{"column.description", "description"},
+		{"column.database", "database"},
+		{"column.from", ""},
+		{"column.to", ""},
+		{"column.change", "change"},
+	}
+
+	databases := []string{}
+	for k := range DataColumns {
+		databases = append(databases, k)
+	}
+	sort.Strings(databases)
+
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			data := MustAsset("favicon.ico")
+			query, err := ParseCandidateQuery(r.URL.Query().Get)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			t, list, err := query.Run(candidates)
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			locale := NegotiateLocale(w, r)
+			order := "asc"
+			if query.Descending {
+				order = "desc"
+			}
+
+			columnData := []column{}
+			for _, v := range columns {
+				c := column{Title: locale.Text(v.title)}
+				if v.sort != "" {
+					// Clicking the active column flips its order
+					c.Active = v.sort == query.Sort
+					c.Descending = query.Descending
+					params := url.Values{}
+					params.Set("sort", v.sort)
+					if c.Active && !query.Descending {
+						params.Set("order", "desc")
+					}
+					if query.Database != "" {
+						params.Set("database", query.Database)
+					}
+					if query.Date != "" {
+						params.Set("date", query.Date)
+					}
+					c.Href = "/leaderboard?" + params.Encode()
+				}
+				columnData = append(columnData, c)
+			}
+
+			databaseData := []option{}
+			for _, v := range databases {
+				databaseData = append(
+					databaseData,
+					option{v, v == query.Database},
+				)
+			}
+
+			dateData := []option{}
+			for _, v := range candidates.Dates() {
+				dateData = append(dateData, option{v, v == query.Date})
+			}
+
+			rows := make([]row, len(list))
+			for i, v := range list {
+				rows[i] = row{
+					Rank:          v.Rank,
+					Symbol:        v.Dataset.Dataset,
+					Description:   v.Dataset.Description,
+					Database:      v.Dataset.Database,
+					OldTime:       locale.Date(v.OldTime),
+					NewTime:       locale.Date(v.NewTime),
+					PercentChange: locale.Percent(100*v.Gain(), 1),
+				}
+			}
 
-			w.Header().Set("Content-Type", "image/x-icon")
-			n, err := w.Write(data)
+			data := map[string]interface{}{
+				"time":      locale.Date(t.In(config.Schedule.Location)),
+				"sort":      query.Sort,
+				"order":     order,
+				"columns":   columnData,
+				"databases": databaseData,
+				"dates":     dateData,
+				"rows":      rows,
+			}
 
+			err = config.Templates.Render(w, locale, "leaderboard", data)
 			if err != nil {
 				panic(err)
 			}
-			if n != len(data) {
-				panic(errors.New("Incomplete write of favicon"))
+		},
+	)
+}
+
+func CalculatorHandler(
+	config Config,
+	selection *SelectionStore,
+) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			locale := NegotiateLocale(w, r)
+
+			// Form values stay in the format the inputs expect, only
+			// the results are localized
+			input := func(f float64) string {
+				return strconv.FormatFloat(f, 'f', -1, 64)
+			}
+			money := func(f float64) string {
+				return locale.Number(f, 2)
+			}
+
+			data := map[string]interface{}{
+				"amount":      input(config.Calculator.Amount),
+				"fee_percent": input(config.Calculator.FeePercent),
+				"fee_flat":    input(config.Calculator.FeeFlat),
+			}
+			render := func(status int) {
+				w.WriteHeader(status)
+				err := config.Templates.Render(w, locale, "calculator", data)
+				if err != nil {
+					panic(err)
+				}
+			}
+
+			q := r.URL.Query()
+			in, err := ParseCalculatorInput(config, q.Get)
+			data["date"] = q.Get("date")
+			data["local"] = in.Local
+			if err != nil {
+				data["error"] = err.Error()
+				render(http.StatusBadRequest)
+				return
+			}
+			data["amount"] = input(in.Amount)
+			data["fee_percent"] = input(in.FeePercent)
+			data["fee_flat"] = input(in.FeeFlat)
+
+			pick, ok := CalculatorSelection(config, in, selection)
+			if !ok {
+				data["error"] = locale.Text("calculator.no_pick", in.Date)
+				render(http.StatusNotFound)
+				return
+			}
+
+			result, err := Calculate(pick, in)
+			if err != nil {
+				data["error"] = err.Error()
+				render(http.StatusBadRequest)
+				return
+			}
+
+			data["result"] = map[string]string{
+				"Description":  pick.Dataset.Description,
+				"OldTime":      locale.Date(pick.OldTime),
+				"NewTime":      locale.Date(pick.NewTime),
+				"Amount":       money(result.Amount),
+				"Final":        money(result.Final),
+				"Percent":      locale.Percent(result.Percent, 1),
+				"Fees":         money(result.BuyFee + result.SellFee),
+				"Currency":     result.Currency,
+				"AmountLocal":  money(result.AmountLocal),
+				"FinalLocal":   money(result.FinalLocal),
+				"LocalPercent": locale.Percent(result.LocalPercentChange, 1),
+			}
+			render(http.StatusOK)
+		},
+	)
+}
+
+func CalculatorAPIHandler(
+	config Config,
+	selection *SelectionStore,
+) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			in, err := ParseCalculatorInput(config, r.URL.Query().Get)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			pick, ok := CalculatorSelection(config, in, selection)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+
+			result, err := Calculate(pick, in)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			data := map[string]interface{}{
+				"database":       pick.Dataset.Database,
+				"dataset":        pick.Dataset.Dataset,
+				"description":    pick.Dataset.Description,
+				"old_time":       pick.OldTime,
+				"new_time":       pick.NewTime,
+				"amount":         result.Amount,
+				"buy_fee":        result.BuyFee,
+				"sell_fee":       result.SellFee,
+				"final":          result.Final,
+				"profit":         result.Profit,
+				"percent_change": result.Percent,
+			}
+			if in.Local {
+				data["currency"] = result.Currency
+				data["amount_local"] = result.AmountLocal
+				data["final_local"] = result.FinalLocal
+				data["local_percent_change"] = result.LocalPercentChange
 			}
+			writeJSON(w, data)
 		},
 	)
 }
@@ -0,0 +1,255 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig is a config for handler tests, with its files in a
+// temporary directory.
+func testConfig(t *testing.T) Config {
+	dir := t.TempDir()
+	schedule, err := ParseSchedule("0 2 * * *", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates, err := LoadTemplates("", SiteConfig{Title: "Test Hindsight"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Config{
+		TempFile:    "cache",
+		Cache:       LocalStorage{Dir: dir},
+		HistoryFile: "history",
+		Schedule:    schedule,
+		Calculator:  CalculatorConfig{Amount: 1000},
+		Site:        SiteConfig{Title: "Test Hindsight"},
+		Templates:   templates,
+		Clock:       SystemClock,
+	}
+}
+
+func getIndex(
+	handler http.Handler,
+	header map[string]string,
+) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://hindsight.test/", nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestIndexHandler(t *testing.T) {
+	store := NewSelectionStore()
+	store.Store(testSelection(0))
+	config := testConfig(t)
+	config.Clock = newFakeClock(time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC))
+	config.Site.URL = "https://site.test/"
+	handler := IndexHandler(config, store)
+
+	w := getIndex(handler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, expected := range []string{
+		"Test Hindsight",
+		"Test dataset",
+		"100%",
+		"<svg",
+		`href="/pick/2017-06-01"`,
+		`content="https://site.test/og.png?date=2017-06-01"`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %q in the index", expected)
+		}
+	}
+
+	if w.Header().Get("Content-Language") != "en" {
+		t.Errorf("Expected English, got %q", w.Header().Get("Content-Language"))
+	}
+	if !strings.HasPrefix(w.Header().Get("Cache-Control"), "public, max-age=") {
+		t.Errorf("Expected caching, got %q", w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestIndexHandlerCaching(t *testing.T) {
+	store := NewSelectionStore()
+	store.Store(testSelection(1))
+	config := testConfig(t)
+	config.Clock = newFakeClock(time.Date(2017, 6, 2, 12, 0, 0, 0, time.UTC))
+	handler := IndexHandler(config, store)
+
+	// Fourteen hours until the next run
+	w := getIndex(handler, nil)
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=50400" {
+		t.Errorf("Expected public caching until the next run, got %q", cc)
+	}
+	vary := w.Header().Get("Vary")
+	for _, v := range []string{"Accept-Encoding", "Accept-Language", "Cookie"} {
+		if !strings.Contains(vary, v) {
+			t.Errorf("Expected Vary to have %s, got %q", v, vary)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "http://hindsight.test/?lang=fr", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if cc := w.Header().Get("Cache-Control"); cc != "private, max-age=50400" {
+		t.Errorf("Expected private caching with a cookie, got %q", cc)
+	}
+
+	if !beginSelection() {
+		t.Fatal("Selection already running")
+	}
+	w = getIndex(handler, nil)
+	endSelection()
+	if cc := w.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Expected no caching during a selection, got %q", cc)
+	}
+
+	// Yesterday's pick is on its way out, as on a follower still
+	// waiting for the leader
+	store.Store(testSelection(0))
+	if cc := getIndex(handler, nil).Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Expected no caching for a stale pick, got %q", cc)
+	}
+}
+
+func TestIndexHandlerLocale(t *testing.T) {
+	store := NewSelectionStore()
+	store.Store(testSelection(0))
+	handler := IndexHandler(testConfig(t), store)
+
+	w := getIndex(handler, map[string]string{"Accept-Language": "de-DE,de"})
+	if w.Header().Get("Content-Language") != "de" {
+		t.Errorf("Expected German, got %q", w.Header().Get("Content-Language"))
+	}
+	if english := getIndex(handler, nil); english.Body.String() == w.Body.String() {
+		t.Error("German and English pages are the same")
+	}
+}
+
+func TestIndexHandlerConditional(t *testing.T) {
+	store := NewSelectionStore()
+	store.Store(testSelection(0))
+	handler := IndexHandler(testConfig(t), store)
+
+	etag := getIndex(handler, nil).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("No ETag on the index")
+	}
+
+	w := getIndex(handler, map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", w.Code)
+	}
+
+	// A new selection is a new page
+	store.Store(testSelection(1))
+	w = getIndex(handler, map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 after a new selection, got %d", w.Code)
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Error("ETag didn't change with the selection")
+	}
+}
+
+func TestIndexHandlerIgnoresHost(t *testing.T) {
+	store := NewSelectionStore()
+	store.Store(testSelection(0))
+	handler := IndexHandler(testConfig(t), store)
+
+	first := getIndex(handler, nil)
+	for i := 0; i < maxCachedPages+1; i++ {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = fmt.Sprintf("evil%d.test", i)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Header().Get("ETag") != first.Header().Get("ETag") {
+			t.Fatalf("Host %s got its own page", r.Host)
+		}
+		if strings.Contains(w.Body.String(), r.Host) {
+			t.Fatalf("Host %s made it into the page", r.Host)
+		}
+	}
+
+	// Without site_url there's nowhere absolute for the card to point
+	body := first.Body.String()
+	if strings.Contains(body, "og:image") || strings.Contains(body, "hindsight.test") {
+		t.Error("Share links made from the request's Host")
+	}
+	if !strings.Contains(body, `<meta property="og:title"`) {
+		t.Error("Expected the rest of the share details")
+	}
+}
+
+func TestIndexHandlerGzip(t *testing.T) {
+	store := NewSelectionStore()
+	store.Store(testSelection(0))
+	handler := IndexHandler(testConfig(t), store)
+
+	plain := getIndex(handler, nil)
+	w := getIndex(handler, map[string]string{"Accept-Encoding": "gzip"})
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if w.Header().Get("ETag") == plain.Header().Get("ETag") {
+		t.Error("Gzipped and plain pages share an ETag")
+	}
+
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != plain.Body.String() {
+		t.Error("Gzipped page doesn't match the plain one")
+	}
+
+	// The same reading of the header as static files get
+	for header, gzipped := range map[string]bool{
+		"gzip;q=0":      false,
+		"gzip;q=bad":    false,
+		"br, gzip;q=.5": true,
+		"identity":      false,
+	} {
+		w := getIndex(handler, map[string]string{"Accept-Encoding": header})
+		if (w.Header().Get("Content-Encoding") == "gzip") != gzipped {
+			t.Errorf("%q: expected gzip %v", header, gzipped)
+		}
+	}
+}
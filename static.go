@@ -0,0 +1,215 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Precompressed variants sit next to the originals, e.g. style.css.gz
+// and style.css.br.  Regenerate them after editing a text file.
+//go:generate sh -c "cd static && for f in *.css *.webmanifest; do gzip -9 -k -f -n $f && brotli -f -Z $f; done"
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// Types mime.TypeByExtension doesn't reliably know about
+var staticTypes map[string]string = map[string]string{
+	".css":         "text/css; charset=utf-8",
+	".ico":         "image/x-icon",
+	".js":          "text/javascript; charset=utf-8",
+	".json":        "application/json",
+	".svg":         "image/svg+xml",
+	".txt":         "text/plain; charset=utf-8",
+	".webmanifest": "application/manifest+json",
+}
+
+// Content encodings we have precompressed variants for, in order of
+// preference
+var staticEncodings []struct{ name, ext string } = []struct{ name, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// StaticFiles serves the built-in static files, or their replacements
+// from an override directory.  Every response carries a strong ETag
+// and a Last-Modified time, and a client that accepts it gets the
+// precompressed variant of a file if there is one.
+type StaticFiles struct {
+	sources []fs.FS
+
+	// Embedded files have no modification times, so they're treated
+	// as modified when the server started
+	started time.Time
+
+	lock  sync.Mutex
+	etags map[string]staticETag
+}
+
+type staticETag struct {
+	modTime time.Time
+	etag    string
+}
+
+func NewStaticFiles(overrideDir string) *StaticFiles {
+	embedded, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	s := &StaticFiles{
+		sources: []fs.FS{embedded},
+		started: time.Now(),
+		etags:   map[string]staticETag{},
+	}
+	if overrideDir != "" {
+		s.sources = append([]fs.FS{os.DirFS(overrideDir)}, s.sources...)
+	}
+	return s
+}
+
+// Handler serves files named by the request path after stripping
+// prefix, so it can be mounted at /static/ as well as for single files
+// like /favicon.ico.
+func (s *StaticFiles) Handler(prefix string) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			name := strings.TrimPrefix(path.Clean(r.URL.Path), prefix)
+			if !fs.ValidPath(name) || name == "." {
+				http.NotFound(w, r)
+				return
+			}
+
+			index, info, ok := s.find(name)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Accept-Encoding")
+			contentType, ok := staticTypes[path.Ext(name)]
+			if !ok {
+				contentType = mime.TypeByExtension(path.Ext(name))
+			}
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+
+			// A variant only counts if it comes from the same place as
+			// the original, so an override is never shadowed by a stale
+			// built-in variant
+			source := s.sources[index]
+			served := name
+			accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+			for _, v := range staticEncodings {
+				if !accepted[v.name] {
+					continue
+				}
+				if _, err := fs.Stat(source, name+v.ext); err == nil {
+					served = name + v.ext
+					w.Header().Set("Content-Encoding", v.name)
+					break
+				}
+			}
+
+			data, err := fs.ReadFile(source, served)
+			if err != nil {
+				panic(err)
+			}
+
+			modTime := info.ModTime()
+			if modTime.IsZero() {
+				modTime = s.started
+			}
+			w.Header().Set("ETag", s.etag(index, served, modTime, data))
+
+			http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+		},
+	)
+}
+
+// find returns the index of the first source with the named file.
+func (s *StaticFiles) find(name string) (int, fs.FileInfo, bool) {
+	for i, source := range s.sources {
+		info, err := fs.Stat(source, name)
+		if err == nil && !info.IsDir() {
+			return i, info, true
+		}
+	}
+	return 0, nil, false
+}
+
+// etag hashes a file's contents, remembering the result until the file
+// changes.
+func (s *StaticFiles) etag(
+	source int,
+	name string,
+	modTime time.Time,
+	data []byte,
+) string {
+	key := strconv.Itoa(source) + ":" + name
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if cached, ok := s.etags[key]; ok && cached.modTime.Equal(modTime) {
+		return cached.etag
+	}
+
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	s.etags[key] = staticETag{modTime, etag}
+	return etag
+}
+
+// acceptedEncodings parses an Accept-Encoding header into the set of
+// encodings it allows, honoring q=0 as a refusal.
+func acceptedEncodings(header string) map[string]bool {
+	out := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+
+		accepted := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		out[name] = accepted
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage holds named blobs somewhere that several replicas can share.
+// Get returns an error satisfying os.IsNotExist for a missing name.
+//
+// Update replaces a blob with whatever update makes of its current
+// contents, which are nil if it's missing, without losing a change
+// another process makes at the same time.  If update fails the blob
+// is left alone.
+type Storage interface {
+	Get(name string) ([]byte, error)
+	Put(name string, data []byte) error
+	Update(name string, update func(data []byte) ([]byte, error)) error
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// localUpdateLock serializes updates within the process, which is all
+// the locking platforms without flock get.
+var localUpdateLock sync.Mutex
+
+// LocalStorage keeps blobs as files in a directory, or relative to
+// the working directory if Dir is empty.
+type LocalStorage struct {
+	Dir string
+}
+
+func (s LocalStorage) Get(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.Dir, name))
+}
+
+// Put writes to a temporary file and renames it into place, so
+// readers never see a partial blob.
+func (s LocalStorage) Put(name string, data []byte) error {
+	path := filepath.Join(s.Dir, name)
+	fout, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fout.Name())
+
+	if _, err := fout.Write(data); err != nil {
+		fout.Close()
+		return err
+	}
+	if err := fout.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fout.Name(), path)
+}
+
+// Update holds a lock on a file next to the blob while it reads and
+// rewrites it, so replicas sharing the directory take turns.
+func (s LocalStorage) Update(
+	name string,
+	update func(data []byte) ([]byte, error),
+) error {
+	localUpdateLock.Lock()
+	defer localUpdateLock.Unlock()
+
+	unlock, err := lockFile(filepath.Join(s.Dir, name+".lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := s.Get(name)
+	if os.IsNotExist(err) {
+		data = nil
+	} else if err != nil {
+		return err
+	}
+	if data, err = update(data); err != nil {
+		return err
+	}
+	return s.Put(name, data)
+}
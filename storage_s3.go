@@ -0,0 +1,266 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps blobs as objects in an S3-compatible bucket.  With
+// PathStyle set, objects are addressed as ENDPOINT/BUCKET/KEY, which
+// is what MinIO and most other stand-ins expect, otherwise as
+// BUCKET.ENDPOINT/KEY like AWS.
+type S3Storage struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+
+	client *http.Client
+}
+
+func NewS3Storage(
+	endpoint string,
+	region string,
+	bucket string,
+	prefix string,
+	accessKey string,
+	secretKey string,
+	pathStyle bool,
+) (*S3Storage, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("No S3 bucket configured")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, err
+	}
+
+	return &S3Storage{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		Prefix:    prefix,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PathStyle: pathStyle,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// s3UpdateAttempts is how many times Update reads and rewrites an
+// object before giving up on other writers getting in first.
+const s3UpdateAttempts = 10
+
+// errS3Conflict is a conditional write losing to another writer.
+var errS3Conflict = errors.New("S3 object changed during update")
+
+func (s *S3Storage) Get(name string) ([]byte, error) {
+	data, _, err := s.get(name)
+	return data, err
+}
+
+// get also returns the object's ETag, for a conditional write.
+func (s *S3Storage) get(name string) ([]byte, string, error) {
+	response, err := s.do("GET", name, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, "", notExist("get", name)
+	} else if response.StatusCode != http.StatusOK {
+		return nil, "", s3Error(response)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	return data, response.Header.Get("ETag"), err
+}
+
+func (s *S3Storage) Put(name string, data []byte) error {
+	return s.put(name, data, nil)
+}
+
+func (s *S3Storage) put(name string, data []byte, header map[string]string) error {
+	response, err := s.do("PUT", name, data, header)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// A conditional write lost, or raced another one
+		return errS3Conflict
+	}
+	return s3Error(response)
+}
+
+// Update writes on the condition that the object hasn't changed since
+// it was read, If-Match its ETag or If-None-Match anything when it
+// was missing, and starts over when another writer got in first.
+func (s *S3Storage) Update(
+	name string,
+	update func(data []byte) ([]byte, error),
+) error {
+	for i := 0; i < s3UpdateAttempts; i++ {
+		data, etag, err := s.get(name)
+		header := map[string]string{"If-Match": etag}
+		if os.IsNotExist(err) {
+			header = map[string]string{"If-None-Match": "*"}
+		} else if err != nil {
+			return err
+		}
+
+		if data, err = update(data); err != nil {
+			return err
+		}
+		err = s.put(name, data, header)
+		if err != errS3Conflict {
+			return err
+		}
+	}
+	return fmt.Errorf("Gave up updating %s: %s", name, errS3Conflict)
+}
+
+func s3Error(response *http.Response) error {
+	body, _ := ioutil.ReadAll(response.Body)
+	return fmt.Errorf("S3 responded %s: %s", response.Status, body)
+}
+
+func (s *S3Storage) do(
+	method string,
+	name string,
+	body []byte,
+	header map[string]string,
+) (*http.Response, error) {
+	uri, _ := url.Parse(s.Endpoint)
+	key := "/" + s.Prefix + name
+	if s.PathStyle {
+		uri.Path += "/" + s.Bucket + key
+	} else {
+		uri.Host = s.Bucket + "." + uri.Host
+		uri.Path += key
+	}
+
+	request, err := http.NewRequest(method, uri.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		request.Header.Set(k, v)
+	}
+	s.sign(request, body, time.Now())
+	return s.client.Do(request)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header.  See
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func (s *S3Storage) sign(request *http.Request, body []byte, t time.Time) {
+	t = t.UTC()
+	stamp := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	request.Header.Set("Host", request.URL.Host)
+	request.Header.Set("X-Amz-Date", stamp)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{}
+	for k := range request.Header {
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+	headers := ""
+	for _, k := range names {
+		headers += k + ":" + strings.TrimSpace(request.Header.Get(k)) + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join(
+		[]string{
+			request.Method,
+			request.URL.EscapedPath(),
+			request.URL.Query().Encode(),
+			headers,
+			signedHeaders,
+			payloadHash,
+		},
+		"\n",
+	)
+
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	toSign := strings.Join(
+		[]string{
+			"AWS4-HMAC-SHA256",
+			stamp,
+			scope,
+			sha256Hex([]byte(canonical)),
+		},
+		"\n",
+	)
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, v := range []string{day, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	request.Header.Set(
+		"Authorization",
+		fmt.Sprintf(
+			"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			s.AccessKey,
+			scope,
+			signedHeaders,
+			signature,
+		),
+	)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"database/sql"
+	_ "github.com/lib/pq"
+)
+
+// SQLStorage keeps blobs as rows in a PostgreSQL table.
+type SQLStorage struct {
+	db *sql.DB
+}
+
+func NewSQLStorage(url string) (*SQLStorage, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS blobs (
+			name TEXT PRIMARY KEY,
+			data BYTEA NOT NULL,
+			updated TIMESTAMPTZ NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLStorage{db}, nil
+}
+
+func (s *SQLStorage) Get(name string) ([]byte, error) {
+	data := []byte{}
+	err := s.db.QueryRow(
+		"SELECT data FROM blobs WHERE name = $1",
+		name,
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, notExist("get", name)
+	}
+	return data, err
+}
+
+func (s *SQLStorage) Put(name string, data []byte) error {
+	_, err := s.db.Exec(
+		`
+		INSERT INTO blobs (name, data, updated) VALUES ($1, $2, now())
+		ON CONFLICT (name) DO UPDATE
+		SET data = EXCLUDED.data, updated = EXCLUDED.updated
+		`,
+		name,
+		data,
+	)
+	return err
+}
+
+// Update reads and rewrites the row in one transaction, holding an
+// advisory lock on the name so concurrent updates wait their turn,
+// even when the row doesn't exist yet to be locked itself.
+func (s *SQLStorage) Update(
+	name string,
+	update func(data []byte) ([]byte, error),
+) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", name)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	err = tx.QueryRow(
+		"SELECT data FROM blobs WHERE name = $1",
+		name,
+	).Scan(&data)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if data, err = update(data); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`
+		INSERT INTO blobs (name, data, updated) VALUES ($1, $2, now())
+		ON CONFLICT (name) DO UPDATE
+		SET data = EXCLUDED.data, updated = EXCLUDED.updated
+		`,
+		name,
+		data,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
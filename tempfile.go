@@ -20,6 +20,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/gob"
 	"io"
 	"time"
@@ -45,3 +46,79 @@ func ReadBackup(fin io.Reader) (DailySelection, error) {
 	}
 	return out, nil
 }
+
+// LoadBackup reads the cached selection from storage.
+func LoadBackup(store Storage, name string) (DailySelection, error) {
+	data, err := store.Get(name)
+	if err != nil {
+		return DailySelection{}, err
+	}
+	return ReadBackup(bytes.NewReader(data))
+}
+
+func SaveBackup(store Storage, name string, selection DailySelection) error {
+	buf := bytes.Buffer{}
+	if err := WriteBackup(&buf, selection); err != nil {
+		return err
+	}
+	return store.Put(name, buf.Bytes())
+}
+
+// seriesName is where the chart series for the pick made on date is
+// kept.  Series are stored apart from the history, so the history
+// stays small and each chart is only read when it's drawn.
+func seriesName(name, date string) string {
+	return name + "-series-" + date
+}
+
+func LoadSeries(store Storage, name, date string) ([]SeriesPoint, error) {
+	data, err := store.Get(seriesName(name, date))
+	if err != nil {
+		return nil, err
+	}
+	series := []SeriesPoint{}
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&series)
+	return series, err
+}
+
+func SaveSeries(store Storage, name, date string, series []SeriesPoint) error {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(series); err != nil {
+		return err
+	}
+	return store.Put(seriesName(name, date), buf.Bytes())
+}
+
+// candidatesName is where the leader leaves the latest candidate list
+// for the other replicas.
+func candidatesName(name string) string {
+	return name + "-candidates"
+}
+
+func LoadCandidates(
+	store Storage,
+	name string,
+) (time.Time, []DailySelection, error) {
+	data, err := store.Get(candidatesName(name))
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	archive := candidateArchive{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&archive); err != nil {
+		return time.Time{}, nil, err
+	}
+	return archive.Time, archive.Candidates, nil
+}
+
+func SaveCandidates(
+	store Storage,
+	name string,
+	t time.Time,
+	list []DailySelection,
+) error {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(candidateArchive{t, list}); err != nil {
+		return err
+	}
+	return store.Put(candidatesName(name), buf.Bytes())
+}
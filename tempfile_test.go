@@ -0,0 +1,85 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	selection := testSelection(3)
+
+	buf := bytes.Buffer{}
+	if err := WriteBackup(&buf, selection); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadBackup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(read, selection) {
+		t.Errorf("Expected %+v, got %+v", selection, read)
+	}
+}
+
+func TestReadBackupInvalid(t *testing.T) {
+	if _, err := ReadBackup(strings.NewReader("not a backup")); err == nil {
+		t.Error("Expected an error reading garbage")
+	}
+}
+
+func TestBackupStorage(t *testing.T) {
+	store := LocalStorage{Dir: t.TempDir()}
+
+	if _, err := LoadBackup(store, "cache"); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing cache, got %v", err)
+	}
+
+	selection := testSelection(5)
+	if err := SaveBackup(store, "cache", selection); err != nil {
+		t.Fatal(err)
+	}
+	read, err := LoadBackup(store, "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, selection) {
+		t.Errorf("Expected %+v, got %+v", selection, read)
+	}
+}
+
+func TestLoadBackupCorrupt(t *testing.T) {
+	store := LocalStorage{Dir: t.TempDir()}
+	if err := store.Put("cache", []byte("not a backup")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A corrupt cache is an error, but not a missing one, so it isn't
+	// mistaken for a first run
+	_, err := LoadBackup(store, "cache")
+	if err == nil || os.IsNotExist(err) {
+		t.Errorf("Expected a decoding error, got %v", err)
+	}
+}
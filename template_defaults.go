@@ -0,0 +1,261 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+// The built-in templates, used for anything not overridden in the
+// template directory.  Pages define "title" and "content" blocks (and
+// optionally "head" and "page_class") for the layout to fill in.
+var defaultTemplates map[string]string = map[string]string{
+	"layout": `<!DOCTYPE HTML>
+<html lang="{{.l.Tag}}">
+	<head>
+		<title>{{block "title" .}}{{.site.Title}}{{end}}</title>
+		<link rel="stylesheet" href="/static/style.css">
+		<link rel="icon" href="/favicon.ico">
+		<link rel="manifest" href="/manifest.webmanifest">
+		<link rel="alternate" type="application/atom+xml"
+			title="{{.site.Title}}" href="/feed.atom">
+		<link rel="alternate" type="application/rss+xml"
+			title="{{.site.Title}}" href="/feed.rss">
+		{{block "head" .}}{{end}}
+	</head>
+
+	<body class="{{block "page_class" .}}{{end}}">
+		<div class="container">
+			{{template "content" .}}
+			{{template "footer" .}}
+		</div>
+	</body>
+</html>
+`,
+
+	"footer": `<p class="footer">
+	{{.l.T "footer.source" (.site.SourceLink)}}
+	{{if .site.ContactEmail}}
+	{{.l.T "footer.contact" (.site.ContactLink)}}
+	{{end}}
+</p>
+<p class="footer">
+	{{.l.T "footer.language"}}
+	{{range .locales}}
+	<a href="?lang={{.Tag}}" hreflang="{{.Tag}}">{{.Name}}</a>
+	{{end}}
+</p>
+`,
+
+	"index": `{{define "page_class"}}index{{end}}
+
+{{define "head"}}
+{{with .share}}
+<meta name="description" content="{{.Description}}">
+<meta property="og:type" content="article">
+<meta property="og:site_name" content="{{$.site.Title}}">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+{{if .URL}}
+<meta property="og:url" content="{{.URL}}">
+<meta property="og:image" content="{{.Image}}">
+<meta property="og:image:type" content="image/png">
+<meta property="og:image:width" content="{{.ImageWidth}}">
+<meta property="og:image:height" content="{{.ImageHeight}}">
+{{end}}
+<meta property="og:locale" content="{{$.l.Tag}}">
+<meta property="article:published_time" content="{{.Published}}">
+<meta name="twitter:card" content="{{if .Image}}summary_large_image{{else}}summary{{end}}">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+{{if .Image}}
+<meta name="twitter:image" content="{{.Image}}">
+{{end}}
+{{end}}
+{{end}}
+
+{{define "content"}}
+<h1>{{.site.Title}}</h1>
+<p class="top">
+	{{if .date}}
+	{{.l.T "index.pick_on" .date .description}}
+	{{else}}
+	{{.l.T "index.pick" .description}}
+	{{end}}
+</p>
+<p class="top">
+	{{if .calendar}}
+	{{.l.T "index.change_calendar" .old_time .new_time .symbol .percent_increase .trading_days .calendar}}
+	{{else}}
+	{{.l.T "index.change" .old_time .new_time .symbol .percent_increase}}
+	{{end}}
+</p>
+{{if .chart}}
+<div class="chart">{{.chart}}</div>
+{{end}}
+<p class="top">
+	<a href="/calculator">{{.l.T "index.calculator" .calculator_amount}}</a>
+	&middot;
+	<a href="{{.permalink}}">{{.l.T "index.permalink"}}</a>
+	&middot;
+	<a href="/feed.atom">{{.l.T "index.feed"}}</a>
+</p>
+<h2>{{.l.T "index.why"}}</h2>
+<p>
+	{{.l.T "index.why_text"}}
+</p>
+{{end}}
+`,
+
+	"status": `{{define "title"}}{{.site.Title}} - {{.l.T "status.title"}}{{end}}
+
+{{define "content"}}
+<h1>{{.l.T "status.heading" .site.Title}}</h1>
+<table class="status">
+	<tr>
+		<th>{{.l.T "status.current"}}</th>
+		<td>{{.description}}</td>
+	</tr>
+	<tr>
+		<th>{{.l.T "status.selected_at"}}</th>
+		<td>{{.selection_time}}</td>
+	</tr>
+	<tr>
+		<th>{{.l.T "status.schedule"}}</th>
+		<td>{{.schedule}} ({{.timezone}})</td>
+	</tr>
+	<tr>
+		<th>{{.l.T "status.next"}}</th>
+		<td>{{if .next_run}}{{.next_run}}{{else}}{{.l.T "status.never"}}{{end}}</td>
+	</tr>
+	{{if .in_progress}}
+	<tr>
+		<th>{{.l.T "status.in_progress"}}</th>
+		<td>{{.l.T "status.yes"}}</td>
+	</tr>
+	{{end}}
+</table>
+{{end}}
+`,
+
+	"leaderboard": `{{define "title"}}{{.site.Title}} - {{.l.T "leaderboard.title"}}{{end}}
+
+{{define "page_class"}}wide{{end}}
+
+{{define "content"}}
+<h1>{{.l.T "leaderboard.heading" .site.Title}}</h1>
+<p class="top">
+	{{.l.T "leaderboard.intro" .time}}
+</p>
+<form method="get" action="/leaderboard">
+	<input type="hidden" name="sort" value="{{.sort}}">
+	<input type="hidden" name="order" value="{{.order}}">
+	<label>
+		{{.l.T "leaderboard.database"}}
+		<select name="database">
+			<option value="">{{.l.T "leaderboard.all"}}</option>
+			{{range .databases}}
+			<option{{if .Selected}} selected{{end}}>{{.Name}}</option>
+			{{end}}
+		</select>
+	</label>
+	{{if .dates}}
+	<label>
+		{{.l.T "leaderboard.date"}}
+		<select name="date">
+			{{range .dates}}
+			<option{{if .Selected}} selected{{end}}>{{.Name}}</option>
+			{{end}}
+		</select>
+	</label>
+	{{end}}
+	<input type="submit" value="{{.l.Text "leaderboard.filter"}}">
+</form>
+<table class="leaderboard">
+	<tr>
+		{{range .columns}}
+		<th>
+			{{if .Href}}
+			<a href="{{.Href}}">{{.Title}}</a>
+			{{else}}
+			{{.Title}}
+			{{end}}
+			{{if .Active}}{{if .Descending}}&darr;{{else}}&uarr;{{end}}{{end}}
+		</th>
+		{{end}}
+	</tr>
+	{{range .rows}}
+	<tr>
+		<td class="number">{{.Rank}}</td>
+		<td>{{.Symbol}}</td>
+		<td>{{.Description}}</td>
+		<td>{{.Database}}</td>
+		<td>{{.OldTime}}</td>
+		<td>{{.NewTime}}</td>
+		<td class="number">{{.PercentChange}}</td>
+	</tr>
+	{{end}}
+</table>
+{{end}}
+`,
+
+	"calculator": `{{define "title"}}{{.site.Title}} - {{.l.T "calculator.title"}}{{end}}
+
+{{define "content"}}
+<h1>{{.l.T "calculator.heading"}}</h1>
+<form method="get" action="/calculator" class="calculator">
+	<label>
+		{{.l.T "calculator.amount"}}
+		<input type="number" name="amount" min="0" step="any"
+			value="{{.amount}}">
+	</label>
+	<label>
+		{{.l.T "calculator.date"}}
+		<input type="date" name="date" value="{{.date}}">
+	</label>
+	<label>
+		{{.l.T "calculator.fee_percent"}}
+		<input type="number" name="fee_percent" min="0" max="100"
+			step="any" value="{{.fee_percent}}">
+	</label>
+	<label>
+		{{.l.T "calculator.fee_flat"}}
+		<input type="number" name="fee_flat" min="0" step="any"
+			value="{{.fee_flat}}">
+	</label>
+	<label>
+		<input type="checkbox" name="currency" value="local"
+			{{if .local}}checked{{end}}>
+		{{.l.T "calculator.local"}}
+	</label>
+	<input type="submit" value="{{.l.Text "calculator.submit"}}">
+</form>
+{{if .error}}
+<p class="error">{{.error}}</p>
+{{end}}
+{{with .result}}
+<p class="top">
+	{{$.l.T "calculator.result" .Amount .Description .OldTime .Final .NewTime .Percent .Fees}}
+</p>
+{{if .Currency}}
+<p class="top">
+	{{$.l.T "calculator.result_local" .Currency .AmountLocal .FinalLocal .LocalPercent}}
+</p>
+{{end}}
+{{end}}
+{{end}}
+`,
+}
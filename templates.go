@@ -0,0 +1,174 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Shared by every page: the layout wraps each page's "content"
+// block, and the other partials can be used from any of them
+var templatePartials []string = []string{"layout", "footer"}
+
+var templatePages []string = []string{
+	"index",
+	"status",
+	"leaderboard",
+	"calculator",
+}
+
+// SiteConfig is the deployment's branding, available to every
+// template as .site.
+type SiteConfig struct {
+	Title        string
+	ContactEmail string
+	SourceURL    string
+
+	// URL is where the site is served from, for absolute links in
+	// feeds.  If it's empty they're built from the request instead.
+	URL string
+}
+
+// SourceLabel is the source URL without its scheme, for display.
+func (s SiteConfig) SourceLabel() string {
+	label := s.SourceURL
+	for _, prefix := range []string{"https://", "http://", "www."} {
+		label = strings.TrimPrefix(label, prefix)
+	}
+	return strings.TrimSuffix(label, "/")
+}
+
+// SourceLink and ContactLink are ready-made links for use as
+// arguments to translated messages.
+func (s SiteConfig) SourceLink() template.HTML {
+	return template.HTML(fmt.Sprintf(
+		`<a href="%s">%s</a>`,
+		template.HTMLEscapeString(s.SourceURL),
+		template.HTMLEscapeString(s.SourceLabel()),
+	))
+}
+
+func (s SiteConfig) ContactLink() template.HTML {
+	email := template.HTMLEscapeString(s.ContactEmail)
+	return template.HTML(fmt.Sprintf(
+		`<a href="mailto:%s">%s</a>`,
+		email,
+		email,
+	))
+}
+
+type Templates struct {
+	site  SiteConfig
+	pages map[string]*template.Template
+}
+
+// LoadTemplates builds every page from the layout, partials and page
+// template.  Each one is read from NAME.html in dir if it exists
+// there, falling back to the built-in default.  Any extra files in
+// dir/partials are made available to every page as well.
+func LoadTemplates(dir string, site SiteConfig) (*Templates, error) {
+	read := func(name string) (string, error) {
+		if dir != "" {
+			data, err := ioutil.ReadFile(filepath.Join(dir, name+".html"))
+			if err == nil {
+				return string(data), nil
+			} else if !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+		return defaultTemplates[name], nil
+	}
+
+	base := template.New("")
+	for _, name := range templatePartials {
+		text, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := base.New(name).Parse(text); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir != "" {
+		extras, err := filepath.Glob(filepath.Join(dir, "partials", "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range extras {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			name := strings.TrimSuffix(filepath.Base(path), ".html")
+			if _, err := base.New(name).Parse(string(data)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	t := &Templates{site: site, pages: map[string]*template.Template{}}
+	for _, name := range templatePages {
+		text, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		page, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := page.New(name).Parse(text); err != nil {
+			return nil, err
+		}
+		t.pages[name] = page
+	}
+
+	return t, nil
+}
+
+// Render executes a page through the layout, adding the site config
+// and locale to its data.
+func (t *Templates) Render(
+	w io.Writer,
+	locale *Locale,
+	page string,
+	data map[string]interface{},
+) error {
+	tmpl, ok := t.pages[page]
+	if !ok {
+		return fmt.Errorf("No template for page %q", page)
+	}
+
+	locales := []*Locale{}
+	for _, v := range LocaleTags() {
+		locales = append(locales, Locales[v])
+	}
+
+	data["site"] = t.site
+	data["l"] = locale
+	data["locales"] = locales
+	return tmpl.ExecuteTemplate(w, "layout", data)
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var tlsVersions map[string]uint16 = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig is how HTTPS is served: from certificate files, or with
+// certificates fetched automatically over ACME for ACMEDomains.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	ACMEDomains   []string
+	ACMEDirectory string
+	ACMEEmail     string
+	ACMECacheDir  string
+
+	// ACMECAFile is a PEM bundle to trust for the ACME directory,
+	// for test servers like Pebble with their own root
+	ACMECAFile string
+
+	MinVersion     string
+	HSTSMaxAge     time.Duration
+	HSTSSubdomains bool
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || len(c.ACMEDomains) > 0
+}
+
+// Servers sets up the HTTPS server for handler, along with the
+// handler for plain HTTP, which redirects to HTTPS and answers ACME
+// challenges.
+func (c TLSConfig) Servers(
+	handler http.Handler,
+	httpsPort int,
+) (*http.Server, http.Handler, error) {
+	minVersion, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		return nil, nil, fmt.Errorf("Unknown TLS version %q", c.MinVersion)
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", httpsPort),
+		Handler: HSTS(c.HSTSMaxAge, c.HSTSSubdomains, handler),
+		TLSConfig: &tls.Config{
+			MinVersion: minVersion,
+		},
+	}
+	redirect := RedirectHandler(httpsPort)
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		server.TLSConfig.Certificates = []tls.Certificate{cert}
+		return server, redirect, nil
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.ACMEDomains...),
+		Cache:      autocert.DirCache(c.ACMECacheDir),
+		Email:      c.ACMEEmail,
+		Client:     &acme.Client{DirectoryURL: c.ACMEDirectory},
+	}
+	if c.ACMECAFile != "" {
+		pem, err := ioutil.ReadFile(c.ACMECAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("No certificates in %s", c.ACMECAFile)
+		}
+		manager.Client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots},
+			},
+		}
+	}
+
+	server.TLSConfig.GetCertificate = manager.GetCertificate
+	server.TLSConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	return server, manager.HTTPHandler(redirect), nil
+}
+
+// RedirectHandler sends plain HTTP requests to the same URL over
+// HTTPS.
+func RedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if httpsPort != 443 {
+				host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+			}
+
+			// Only GET and HEAD can safely be turned into a GET
+			status := http.StatusPermanentRedirect
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				status = http.StatusMovedPermanently
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+		},
+	)
+}
+
+// HSTS tells browsers to only use HTTPS for the next maxAge.  A zero
+// maxAge leaves the header off.
+func HSTS(maxAge time.Duration, subdomains bool, in http.Handler) http.Handler {
+	if maxAge <= 0 {
+		return in
+	}
+
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	if subdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", value)
+			in.ServeHTTP(w, r)
+		},
+	)
+}
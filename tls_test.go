@@ -0,0 +1,106 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestACMEServers(t *testing.T) {
+	cacheDir := t.TempDir()
+	config := TLSConfig{
+		ACMEDomains: []string{"hindsight.test"},
+		// Nothing here should ever reach the directory
+		ACMEDirectory: "http://127.0.0.1:1/directory",
+		ACMECacheDir:  cacheDir,
+		MinVersion:    "1.2",
+		HSTSMaxAge:    24 * time.Hour,
+	}
+	server, plain, err := config.Servers(http.NotFoundHandler(), 8443)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.TLSConfig.GetCertificate(
+		&tls.ClientHelloInfo{ServerName: "elsewhere.test"},
+	)
+	if err == nil {
+		t.Error("Expected a certificate to be refused for elsewhere.test")
+	}
+
+	request := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		plain.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	// Anything other than a challenge goes to HTTPS, keeping the
+	// method for anything but GET and HEAD
+	for method, status := range map[string]int{
+		"GET":  http.StatusMovedPermanently,
+		"HEAD": http.StatusMovedPermanently,
+		"POST": http.StatusPermanentRedirect,
+	} {
+		w := request(method, "http://hindsight.test/pick/2017-06-01?lang=fr")
+		if w.Code != status {
+			t.Errorf("Expected %d for %s, got %d", status, method, w.Code)
+		}
+		expected := "https://hindsight.test:8443/pick/2017-06-01?lang=fr"
+		if location := w.Header().Get("Location"); location != expected {
+			t.Errorf("Expected a redirect to %s, got %s", expected, location)
+		}
+	}
+
+	// Challenge tokens are answered from the cache, but only for
+	// allowed hosts
+	token := filepath.Join(cacheDir, "sometoken+http-01")
+	if err := ioutil.WriteFile(token, []byte("sometoken.thumbprint"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	challenge := "/.well-known/acme-challenge/sometoken"
+
+	w := request("GET", "http://hindsight.test"+challenge)
+	if w.Code != http.StatusOK || w.Body.String() != "sometoken.thumbprint" {
+		t.Errorf("Expected the token, got %d %q", w.Code, w.Body.String())
+	}
+	if w := request("GET", "http://elsewhere.test"+challenge); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a challenge for elsewhere.test to be refused, got %d", w.Code)
+	}
+	missing := "http://hindsight.test/.well-known/acme-challenge/other"
+	if w := request("GET", missing); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown token to be missing, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "https://hindsight.test/", nil))
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "max-age=86400" {
+		t.Errorf("Expected HSTS for a day, got %q", hsts)
+	}
+	if strings.Join(server.TLSConfig.NextProtos, " ") != "h2 http/1.1 acme-tls/1" {
+		t.Errorf("Unexpected protocols %v", server.TLSConfig.NextProtos)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Built-in payload formats.  Chat services only need a message, the
+// generic "json" format sends the whole WebhookPayload.
+var webhookFormats map[string]string = map[string]string{
+	"json":       `{{json .}}`,
+	"slack":      `{"text": {{json .Text}}}`,
+	"discord":    `{"content": {{json .Text}}, "username": {{json .Site}}}`,
+	"mattermost": `{"text": {{json .Text}}, "username": {{json .Site}}}`,
+}
+
+// WebhookConfig is one entry in the webhooks config list.  Template,
+// if set, replaces the format's built-in payload template.
+type WebhookConfig struct {
+	Name     string
+	URL      string
+	Format   string
+	Secret   string
+	Template string
+	Locale   string
+}
+
+// WebhookPayload is the data available to payload templates.
+type WebhookPayload struct {
+	Event         string    `json:"event"`
+	Site          string    `json:"site"`
+	Date          string    `json:"date"`
+	Database      string    `json:"database"`
+	Dataset       string    `json:"dataset"`
+	Description   string    `json:"description"`
+	OldTime       time.Time `json:"old_time"`
+	NewTime       time.Time `json:"new_time"`
+	OldValue      float64   `json:"old_value"`
+	NewValue      float64   `json:"new_value"`
+	PercentChange float64   `json:"percent_change"`
+	Permalink     string    `json:"permalink"`
+	Text          string    `json:"text"`
+}
+
+type Webhook struct {
+	config   Config
+	target   WebhookConfig
+	locale   *Locale
+	template *template.Template
+	client   *http.Client
+}
+
+func NewWebhook(config Config, target WebhookConfig) (*Webhook, error) {
+	if target.URL == "" {
+		return nil, fmt.Errorf("Webhook %q has no URL", target.Name)
+	}
+	if config.Site.URL == "" {
+		// Receivers have no request to resolve a relative permalink
+		// against
+		return nil, fmt.Errorf("Webhook %q needs site_url set", target.Name)
+	}
+	endpoint, err := url.Parse(target.URL)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("Webhook %q has an invalid URL", target.Name)
+	}
+	if target.Format == "" {
+		target.Format = "json"
+	}
+
+	// Chat services put the secret in the URL, so only its host ever
+	// goes in the logs
+	if target.Name == "" {
+		target.Name = target.Format + "@" + endpoint.Host
+	}
+
+	text := target.Template
+	if text == "" {
+		var ok bool
+		text, ok = webhookFormats[target.Format]
+		if !ok {
+			return nil, fmt.Errorf(
+				"Unknown webhook format %q for %s",
+				target.Format,
+				target.Name,
+			)
+		}
+	}
+
+	funcs := template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}
+	tmpl, err := template.New(target.Name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	locale := Locales["en"]
+	if target.Locale != "" {
+		locale = MatchLocale(target.Locale)
+	}
+
+	return &Webhook{
+		config:   config,
+		target:   target,
+		locale:   locale,
+		template: tmpl,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (h *Webhook) Name() string {
+	return "webhook " + h.target.Name
+}
+
+func (h *Webhook) Payload(selection DailySelection) WebhookPayload {
+	date := selection.Time.In(h.config.Schedule.Location).Format(timeFormat)
+	permalink := strings.TrimSuffix(h.config.Site.URL, "/") +
+		PermalinkPath(date)
+
+	percent := 100 * selection.Gain()
+	text := h.locale.Text(
+		"feed.entry_summary",
+		selection.Dataset.Description,
+		selection.Dataset.Key(),
+		h.locale.Percent(percent, 0),
+		h.locale.Date(selection.OldTime),
+		h.locale.Date(selection.NewTime),
+	)
+
+	return WebhookPayload{
+		Event:         "selection",
+		Site:          h.config.Site.Title,
+		Date:          date,
+		Database:      selection.Dataset.Database,
+		Dataset:       selection.Dataset.Dataset,
+		Description:   selection.Dataset.Description,
+		OldTime:       selection.OldTime,
+		NewTime:       selection.NewTime,
+		OldValue:      selection.OldValue,
+		NewValue:      selection.NewValue,
+		PercentChange: percent,
+		Permalink:     permalink,
+		Text:          text + " " + permalink,
+	}
+}
+
+// Notify posts the payload.  If the target has a secret, the request
+// carries X-Hindsight-Timestamp and an X-Hindsight-Signature of
+// "sha256=" and the hex HMAC-SHA256 of the timestamp, a ".", and the
+// body, so receivers can reject forged or replayed requests.
+func (h *Webhook) Notify(selection DailySelection) error {
+	body := bytes.Buffer{}
+	err := h.template.Execute(&body, h.Payload(selection))
+	if err != nil {
+		return PermanentError{err}
+	}
+
+	request, err := http.NewRequest("POST", h.target.URL, &body)
+	if err != nil {
+		return PermanentError{err}
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "dailyhindsight")
+	if h.target.Secret != "" {
+		timestamp := strconv.FormatInt(h.config.Clock.Now().Unix(), 10)
+		request.Header.Set("X-Hindsight-Timestamp", timestamp)
+		request.Header.Set(
+			"X-Hindsight-Signature",
+			"sha256="+SignWebhook(h.target.Secret, timestamp, body.Bytes()),
+		)
+	}
+
+	response, err := h.client.Do(request)
+	if err != nil {
+		// Which quotes the whole URL
+		if urlErr, ok := err.(*url.Error); ok {
+			err = fmt.Errorf("%s to %s: %s", urlErr.Op, h.target.Name, urlErr.Err)
+		}
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("%s responded %s", h.target.Name, response.Status)
+	if response.StatusCode < 500 &&
+		response.StatusCode != http.StatusTooManyRequests {
+		return PermanentError{err}
+	}
+	return err
+}
+
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
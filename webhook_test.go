@@ -0,0 +1,293 @@
+/*
+ * Copyright 2017, Robert Bieber
+ *
+ * This file is part of dailyhindsight.
+ *
+ * dailyhindsight is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * dailyhindsight is distributed in the hope that it will be useful,
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with dailyhindsight.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver answers with each status in turn, then 200s, and
+// keeps every request it gets.
+type webhookReceiver struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	receiver := &webhookReceiver{statuses: statuses}
+	receiver.Server = httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+
+				receiver.lock.Lock()
+				defer receiver.lock.Unlock()
+				receiver.bodies = append(receiver.bodies, body)
+				receiver.headers = append(receiver.headers, r.Header)
+				status := http.StatusOK
+				if len(receiver.statuses) > 0 {
+					status = receiver.statuses[0]
+					receiver.statuses = receiver.statuses[1:]
+				}
+				w.WriteHeader(status)
+			},
+		),
+	)
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func (r *webhookReceiver) requests() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.bodies)
+}
+
+func webhookTest(t *testing.T, url string) (*Notifications, string) {
+	config := testConfig(t)
+	config.Site.URL = "https://hindsight.test/"
+	config.Clock = newFakeClock(time.Date(2017, 6, 1, 2, 0, 0, 0, time.UTC))
+	webhook, err := NewWebhook(config, WebhookConfig{
+		Name:   "test",
+		URL:    url,
+		Secret: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "dead-letter")
+	fout, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		fout.Close()
+	})
+
+	return &Notifications{
+		Notifiers:  []Notifier{webhook},
+		Retries:    2,
+		Backoff:    time.Minute,
+		DeadLetter: log.New(fout, "", 0),
+		Clock:      config.Clock,
+	}, path
+}
+
+func deadLetters(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if lines[0] == "" {
+		return nil
+	}
+	return lines
+}
+
+func TestWebhookHidesURL(t *testing.T) {
+	config := testConfig(t)
+	config.Site.URL = "https://hindsight.test/"
+	config.Clock = newFakeClock(time.Date(2017, 6, 1, 2, 0, 0, 0, time.UTC))
+
+	// Nothing listens here, so every attempt fails with an error that
+	// would quote the URL
+	webhook, err := NewWebhook(config, WebhookConfig{
+		URL:    "http://127.0.0.1:1/services/T000/B000/SECRET",
+		Format: "slack",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := webhook.Name(); name != "webhook slack@127.0.0.1:1" {
+		t.Errorf("Expected the format and host as the name, got %q", name)
+	}
+
+	logged := bytes.Buffer{}
+	log.SetOutput(&logged)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+	})
+
+	path := filepath.Join(t.TempDir(), "dead-letter")
+	fout, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	notifications := &Notifications{
+		Notifiers:  []Notifier{webhook},
+		Retries:    1,
+		DeadLetter: log.New(fout, "", 0),
+		Clock:      config.Clock,
+	}
+	notifications.Send(testSelection(0))
+	notifications.Wait()
+
+	letters := deadLetters(t, path)
+	if len(letters) != 1 {
+		t.Fatalf("Expected a dead letter, got %d", len(letters))
+	}
+	for name, v := range map[string]string{
+		"log":         logged.String(),
+		"dead letter": letters[0],
+	} {
+		if !strings.Contains(v, "slack@127.0.0.1:1") {
+			t.Errorf("The %s doesn't name the webhook: %s", name, v)
+		}
+		if strings.Contains(v, "SECRET") {
+			t.Errorf("The %s has the webhook URL: %s", name, v)
+		}
+	}
+}
+
+func TestWebhookNeedsSiteURL(t *testing.T) {
+	_, err := NewWebhook(testConfig(t), WebhookConfig{URL: "http://x.test/"})
+	if err == nil {
+		t.Error("Expected an error for a webhook without site_url")
+	}
+}
+
+func TestWebhookSigned(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	notifications, path := webhookTest(t, receiver.URL)
+	notifications.Send(testSelection(0))
+	notifications.Wait()
+
+	if receiver.requests() != 1 {
+		t.Fatalf("Expected 1 request, got %d", receiver.requests())
+	}
+	header, body := receiver.headers[0], receiver.bodies[0]
+	timestamp := header.Get("X-Hindsight-Timestamp")
+	if timestamp != "1496282400" {
+		t.Errorf("Expected the timestamp from the clock, got %s", timestamp)
+	}
+	expected := "sha256=" + SignWebhook("secret", timestamp, body)
+	if !hmac.Equal([]byte(header.Get("X-Hindsight-Signature")), []byte(expected)) {
+		t.Errorf("Signature doesn't cover the timestamp and body")
+	}
+	if SignWebhook("secret", timestamp+"0", body) == SignWebhook("secret", timestamp, body) {
+		t.Error("Signature ignores the timestamp")
+	}
+
+	payload := WebhookPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Permalink != "https://hindsight.test/pick/2017-06-01" {
+		t.Errorf("Wrong permalink %q", payload.Permalink)
+	}
+	if len(deadLetters(t, path)) != 0 {
+		t.Error("Delivered notification was dead-lettered")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	receiver := newWebhookReceiver(
+		t,
+		http.StatusServiceUnavailable,
+		http.StatusTooManyRequests,
+	)
+	notifications, path := webhookTest(t, receiver.URL)
+	notifications.Send(testSelection(0))
+	notifications.Wait()
+
+	if receiver.requests() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", receiver.requests())
+	}
+	if timestamp := receiver.headers[2].Get("X-Hindsight-Timestamp"); timestamp != "1496282580" {
+		t.Errorf("Expected the last attempt after 3 minutes of backoff, got %s", timestamp)
+	}
+	if len(deadLetters(t, path)) != 0 {
+		t.Error("Notification that got through was dead-lettered")
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	cases := []struct {
+		name     string
+		statuses []int
+		attempts int
+	}{
+		{"permanent", []int{http.StatusBadRequest}, 1},
+		{
+			"out of retries",
+			[]int{
+				http.StatusBadGateway,
+				http.StatusBadGateway,
+				http.StatusBadGateway,
+			},
+			3,
+		},
+	}
+
+	for _, c := range cases {
+		receiver := newWebhookReceiver(t, c.statuses...)
+		notifications, path := webhookTest(t, receiver.URL)
+		notifications.Send(testSelection(0))
+		notifications.Wait()
+
+		if receiver.requests() != c.attempts {
+			t.Errorf(
+				"%s: expected %d attempts, got %d",
+				c.name,
+				c.attempts,
+				receiver.requests(),
+			)
+		}
+
+		letters := deadLetters(t, path)
+		if len(letters) != 1 {
+			t.Fatalf("%s: expected 1 dead letter, got %d", c.name, len(letters))
+		}
+		letter := struct {
+			Notifier  string
+			Error     string
+			Selection DailySelection
+		}{}
+		if err := json.Unmarshal([]byte(letters[0]), &letter); err != nil {
+			t.Fatal(err)
+		}
+		if letter.Notifier != "webhook test" ||
+			letter.Selection.Dataset.Key() != "TEST/SET" {
+			t.Errorf("%s: wrong dead letter %s", c.name, letters[0])
+		}
+		if len(letter.Selection.Series) != 0 {
+			t.Errorf("%s: dead letter has the whole series", c.name)
+		}
+	}
+}